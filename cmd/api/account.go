@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+)
+
+// exportCurrentUserHandler sends the current user a copy of everything that we hold
+// about them, as a JSON file. Secrets such as password and token hashes are left out,
+// because they are useless to the user and dangerous to hand around.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	// An administrator impersonating the user may look around, but not take a copy of
+	// all of their data.
+	if user.Impersonator != nil {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	roles, err := app.modelsFor(r).Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	tokens, err := app.modelsFor(r).Tokens.GetMetadataForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.modelsFor(r).APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only say whether two-factor authentication is set up, not what the secret is.
+	var totp any
+	enrollment, err := app.modelsFor(r).TOTP.Get(user.ID)
+	switch {
+	case err == nil:
+		totp = map[string]any{"enabled": enrollment.Enabled, "created_at": enrollment.CreatedAt}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	clients, err := app.modelsFor(r).OAuthClients.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	consents, err := app.modelsFor(r).OAuthConsents.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var emailChange any
+	change, err := app.modelsFor(r).EmailChanges.Get(user.ID)
+	switch {
+	case err == nil:
+		emailChange = map[string]any{"email": change.Email, "created_at": change.CreatedAt}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := app.modelsFor(r).Movie.GetAllForCreator(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	audit, err := app.modelsFor(r).Audit.GetAllForTarget(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var deletion *data.UserDeletion
+	deletion, err = app.modelsFor(r).Deletions.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	export := envelope{
+		"exported_at":          time.Now().UTC(),
+		"user":                 user,
+		"roles":                roles,
+		"permissions":          permissions,
+		"tokens":               tokens,
+		"api_keys":             apiKeys,
+		"totp":                 totp,
+		"oauth_clients":        clients,
+		"oauth_consents":       consents,
+		"pending_email_change": emailChange,
+		"movies":               movies,
+		"audit":                audit,
+		"pending_deletion":     deletion,
+	}
+
+	// Ask browsers to save the response as a file rather than display it.
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenlight-export-%d.json"`, user.ID))
+
+	err = app.writeJSON(w, http.StatusOK, export, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// requestAccountDeletionHandler schedules the current user's account for deletion once
+// the grace period has passed. The user has to confirm their password, and can carry
+// on using the account, and cancel the deletion, until then.
+func (app *application) requestAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.verifyCurrentPassword(w, r, user, input.Password, "password") {
+		return
+	}
+
+	deletion, err := app.modelsFor(r).Deletions.Schedule(user.ID, time.Now().Add(app.config().deletion.gracePeriod))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":         user.Name,
+			"scheduledFor": deletion.ScheduledFor.UTC().Format(time.RFC1123),
+		}
+
+		err := app.mailer.Send(r.Context(), user.Email, "account_deletion_scheduled.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"deletion": deletion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelAccountDeletionHandler keeps the current user's account, if its deletion is
+// still pending.
+func (app *application) cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	err := app.modelsFor(r).Deletions.Cancel(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account deletion cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// impersonationTTL is how long an impersonation token lasts. Support sessions are short,
+// and a new token can always be minted.
+const impersonationTTL = 15 * time.Minute
+
+// listUsersHandler lets an administrator search and page through every user account.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.modelsFor(r).User.GetAll(input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler returns a single user along with their roles and the permission codes
+// that they have been granted, either directly or through a role.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	roles, err := app.modelsFor(r).Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserAuditHandler returns the administrative changes made to a user, newest first.
+func (app *application) listUserAuditHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := app.modelsFor(r).Audit.GetAllForTarget(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantPermissionsHandler grants one or more permission codes directly to a user.
+// Codes which the user already has are left alone.
+func (app *application) grantPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.modelsFor(r).Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range input.Permissions {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("%q is not a known permission", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.auditEntry(r, user.ID, data.AuditUserPermissionGranted, map[string]any{"permissions": input.Permissions})
+
+	err = app.modelsFor(r).Permissions.AddForUser(user.ID, input.Permissions, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokePermissionHandler removes a permission code that was granted directly to a
+// user. A code the user gets from one of their roles is unaffected, so it will still
+// show up in their permissions afterwards.
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	known, err := app.modelsFor(r).Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(known.Include(code), "code", fmt.Sprintf("%q is not a known permission", code))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.auditEntry(r, user.ID, data.AuditUserPermissionRevoked, map[string]any{"permissions": []string{code}})
+
+	err = app.modelsFor(r).Permissions.RemoveForUser(user.ID, []string{code}, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserActivationHandler force-activates or deactivates a user account. A user
+// who is deactivated is also signed out everywhere, since requireActivatedUser() would
+// refuse their existing sessions anyway.
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Don't let an administrator lock themselves out of the admin API by accident.
+	if !*input.Activated && user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("you cannot deactivate your own account"))
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	action := data.AuditUserActivated
+	if !user.Activated {
+		action = data.AuditUserDeactivated
+	}
+
+	err = app.modelsFor(r).User.UpdateActivation(user, app.auditEntry(r, user.ID, action, nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserHandler permanently removes a user account along with everything that
+// belongs to it.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == app.contextGetUser(r).ID {
+		app.badRequestResponse(w, r, errors.New("you cannot delete your own account"))
+		return
+	}
+
+	// The audit log keeps the target's ID without a foreign key, so the entry outlives
+	// the account. Record the email address too, since the ID alone won't mean much
+	// once the user is gone.
+	audit := app.auditEntry(r, user.ID, data.AuditUserDeleted, map[string]any{"email": user.Email})
+
+	err := app.modelsFor(r).User.Delete(user.ID, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// impersonateUserHandler mints a short-lived token which lets the current administrator
+// see the API as the target user does. See authenticateImpersonation() for what the
+// token can do.
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	if user.ID == admin.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot impersonate yourself"))
+		return
+	}
+
+	// Impersonating another administrator who can impersonate would let one admin
+	// borrow the other's access, so it isn't allowed.
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions.Include("admin:impersonate") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	audit := app.auditEntry(r, user.ID, data.AuditUserImpersonated, nil)
+
+	token, err := app.modelsFor(r).Tokens.NewImpersonation(user.ID, admin.ID, impersonationTTL, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"impersonation_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showLogLevelHandler returns the minimum level that is currently being logged.
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logLevel.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum level that is logged, without a restart.
+// This makes it possible to turn on debug logging while investigating a problem. The
+// change lasts until the application is restarted.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var level slog.Level
+
+	v := validator.New()
+	v.Check(level.UnmarshalText([]byte(input.Level)) == nil, "level", "must be one of debug, info, warn or error")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+
+	app.logger.WarnContext(r.Context(), "log level changed", "from", previous.String(), "to", level.String())
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam() fetches the user named by the :id URL parameter. If the user can't
+// be found an error response has already been sent, and the caller should simply
+// return.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.modelsFor(r).User.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// auditEntry() describes a change made by the current user to another user's account,
+// for the audit log. It is passed to the model method which makes the change, so that
+// the change and the entry are saved together.
+func (app *application) auditEntry(r *http.Request, targetUserID int64, action string, details map[string]any) *data.AuditEntry {
+	return &data.AuditEntry{
+		ActorID:      app.contextGetUser(r).ID,
+		TargetUserID: targetUserID,
+		Action:       action,
+		Details:      details,
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/conffile"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/vcs"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// configEnvPrefix starts the name of every environment variable that the configuration
+// is read from.
+const configEnvPrefix = "GREENLIGHT_"
+
+// secretFlags are the settings which hold credentials. Each one can also be read from a
+// file, with a flag of the same name ending in -file such as -smtp-password-file, which
+// suits secrets mounted into a container. The same goes for the config file and the
+// environment, as smtp.password-file or GREENLIGHT_SMTP_PASSWORD_FILE.
+var secretFlags = []string{"db-dsn", "smtp-username", "smtp-password", "metrics-password"}
+
+// configActions are the flags which ask for something other than starting the server.
+type configActions struct {
+	// check is set by -config-check, to check the configuration and exit.
+	check bool
+	// version is set by -version, to print the build information and exit.
+	version bool
+}
+
+// commandLineOnly are the flags which can only be given on the command line. The
+// environment often holds a variable such as GREENLIGHT_VERSION describing the
+// deployment, which mustn't stop the server from starting.
+var commandLineOnly = []string{"config", "version"}
+
+// readConfig() registers the application's flags on fs and reads the configuration,
+// with args as the command-line arguments. It also reports whether -config-check or
+// -version were given. The configuration hasn't been validated yet.
+func readConfig(fs *flag.FlagSet, args []string) (config, configActions, error) {
+	var cfg config
+	registerFlags(fs, &cfg)
+
+	var actions configActions
+	fs.BoolVar(&actions.check, "config-check", false, "Check the configuration and exit")
+	fs.BoolVar(&actions.version, "version", false, "Print the build information and exit")
+
+	err := loadConfig(fs, args)
+	return cfg, actions, err
+}
+
+// loadConfig() sets the flags registered on fs from, in order of increasing precedence:
+//
+//   - their defaults;
+//   - the config file named by -config or GREENLIGHT_CONFIG, in which a table groups
+//     flags with a common prefix, so that host under [smtp] sets -smtp-host;
+//   - environment variables, named after the flag in upper case with dashes replaced by
+//     underscores, such as GREENLIGHT_SMTP_HOST;
+//   - the command-line arguments.
+//
+// Every setting in the file and the environment is applied before an error is returned,
+// so that all of the problems are reported together.
+func loadConfig(fs *flag.FlagSet, args []string) error {
+
+	configFile := fs.String("config", os.Getenv(configEnvPrefix+"CONFIG"), "Path to a TOML config file")
+
+	for _, name := range secretFlags {
+		fs.Func(name+"-file", "Read "+name+" from this file", func(path string) error {
+			secret, err := readSecretFile(path)
+			if err != nil {
+				return err
+			}
+			return fs.Set(name, secret)
+		})
+	}
+
+	// A bad command-line argument is reported along with everything else, unless it
+	// was -help, which only wants the usage message.
+	var errs []error
+
+	err := parseFlags(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Settings made on the command line win, so note which they are. Setting a secret
+	// from a file counts as setting the secret itself.
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[strings.TrimSuffix(f.Name, "-file")] = true
+	})
+
+	type setting struct {
+		values []string
+		source string
+		// fromFile is true when the value is the path of a file holding a secret.
+		fromFile bool
+	}
+
+	settings := make(map[string]setting)
+
+	add := func(name string, values []string, source string) {
+		secret, isFile := strings.CutSuffix(name, "-file")
+		if isFile && slices.Contains(secretFlags, secret) {
+			settings[secret] = setting{values: values, source: source, fromFile: true}
+			return
+		}
+
+		if slices.Contains(commandLineOnly, name) || fs.Lookup(name) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", source, name))
+			return
+		}
+		settings[name] = setting{values: values, source: source}
+	}
+
+	if *configFile != "" {
+		values, err := conffile.ReadFile(*configFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for name, v := range values {
+			add(name, v, *configFile)
+		}
+	}
+
+	// Environment variables are looked up for every flag, and override the file.
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(commandLineOnly, f.Name) {
+			return
+		}
+
+		env := configEnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok {
+			add(f.Name, []string{value}, env)
+		}
+	})
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if onCommandLine[name] {
+			continue
+		}
+
+		s := settings[name]
+		for _, value := range s.values {
+			if s.fromFile {
+				value, err = readSecretFile(value)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %w", s.source, name, err))
+					break
+				}
+			}
+
+			err := fs.Set(name, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: invalid value %q: %w", s.source, name, value, err))
+				break
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseFlags() parses args like fs.Parse(), but carries on after a bad argument, so that
+// every one of them is reported. fs must use flag.ContinueOnError.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var errs []error
+
+	for {
+		err := fs.Parse(args)
+		if err == nil || errors.Is(err, flag.ErrHelp) {
+			return errors.Join(append(errs, err)...)
+		}
+		errs = append(errs, err)
+
+		// Parse() stops at the bad argument, having usually consumed it. If it didn't,
+		// as with "---x", skip it here so that parsing always moves on.
+		rest := fs.Args()
+		if len(rest) == len(args) {
+			rest = rest[1:]
+		}
+		args = rest
+	}
+}
+
+// readSecretFile() returns the contents of a file holding a secret, without the
+// surrounding whitespace that editors and echo tend to add.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// validate() checks the whole configuration, and records every problem in v keyed by the
+// name of the setting.
+func (cfg config) validate(v *validator.Validator) {
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	var level slog.Level
+	v.Check(level.UnmarshalText([]byte(cfg.logLevel)) == nil, "log-level", "must be debug, info, warn or error")
+
+	v.Check(cfg.accessLog.sample >= 0 && cfg.accessLog.sample <= 1, "access-log-sample", "must be between 0 and 1")
+	v.Check(cfg.accessLog.slow >= 0, "access-log-slow", "must not be negative")
+
+	v.Check(cfg.metrics.username == "" || cfg.metrics.password != "", "metrics-password", "must be set along with metrics-username")
+
+	v.Check(validator.In(cfg.tracing.exporter, "none", "stdout", "file"), "tracing-exporter", "must be none, stdout or file")
+	v.Check(cfg.tracing.exporter != "file" || cfg.tracing.file != "", "tracing-file", "must be set when tracing-exporter is file")
+	v.Check(cfg.tracing.sample >= 0 && cfg.tracing.sample <= 1, "tracing-sample", "must be between 0 and 1")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	_, err = mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be an email address, optionally with a name")
+
+	v.Check(cfg.login.backoffAfter >= 0, "login-backoff-after", "must not be negative")
+	v.Check(cfg.login.backoffBase >= 0, "login-backoff-base", "must not be negative")
+	v.Check(cfg.login.lockoutAfter >= 0, "login-lockout-after", "must not be negative")
+	v.Check(cfg.login.ipLockoutAfter >= 0, "login-ip-lockout-after", "must not be negative")
+	v.Check(cfg.login.lockoutDuration >= 0, "login-lockout-duration", "must not be negative")
+
+	v.Check(cfg.permissions.cacheTTL >= 0, "permissions-cache-ttl", "must not be negative")
+	v.Check(cfg.permissions.cacheTTL == 0 || cfg.permissions.cacheSize > 0, "permissions-cache-size", "must be greater than zero")
+
+	v.Check(validator.In(cfg.passwords.hasher, "argon2id", "bcrypt"), "password-hasher", "must be argon2id or bcrypt")
+	v.Check(cfg.passwords.bcryptCost >= bcrypt.MinCost && cfg.passwords.bcryptCost <= bcrypt.MaxCost, "bcrypt-cost", fmt.Sprintf("must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	v.Check(cfg.passwords.argon2.iterations >= 1 && cfg.passwords.argon2.iterations <= 255, "argon2-iterations", "must be between 1 and 255")
+	v.Check(cfg.passwords.argon2.parallelism >= 1 && cfg.passwords.argon2.parallelism <= 255, "argon2-parallelism", "must be between 1 and 255")
+	v.Check(cfg.passwords.argon2.memory >= 8*cfg.passwords.argon2.parallelism, "argon2-memory", "must be at least 8 KiB per unit of argon2-parallelism")
+	if cfg.passwords.breachedFile != "" {
+		_, err = os.Stat(cfg.passwords.breachedFile)
+		v.Check(err == nil, "password-breached-file", "must be a readable file")
+	}
+
+	v.Check(cfg.deletion.gracePeriod >= 0, "deletion-grace-period", "must not be negative")
+	v.Check(cfg.deletion.interval > 0, "deletion-interval", "must be greater than zero")
+
+	v.Check(cfg.limiter.anonymous.Rate > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.anonymous.Burst >= 1, "limiter-burst", "must be at least 1")
+	v.Check(cfg.limiter.user.Rate > 0, "limiter-user-rps", "must be greater than zero")
+	v.Check(cfg.limiter.user.Burst >= 1, "limiter-user-burst", "must be at least 1")
+	v.Check(cfg.limiter.ip.Rate > 0, "limiter-ip-rps", "must be greater than zero")
+	v.Check(cfg.limiter.ip.Burst >= 1, "limiter-ip-burst", "must be at least 1")
+
+	v.Check(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+
+	v.Check(cfg.health.timeout > 0, "health-timeout", "must be greater than zero")
+	v.Check(cfg.shutdown.drainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+}
+
+// printVersion() writes the build information for -version.
+func printVersion(w io.Writer) {
+	build := vcs.Get()
+
+	fmt.Fprintf(w, "Version:     %s\n", build.Version)
+	fmt.Fprintf(w, "Commit:      %s\n", build.Commit)
+	fmt.Fprintf(w, "Commit time: %s\n", build.CommitTime)
+	fmt.Fprintf(w, "Build time:  %s\n", build.BuildTime)
+	fmt.Fprintf(w, "Go version:  %s\n", build.GoVersion)
+	fmt.Fprintf(w, "Modified:    %t\n", build.Modified)
+}
+
+// writeConfigErrors() lists the problems found while reading the configuration, if err
+// isn't nil, followed by those found by validate() in order of setting name, one per
+// line.
+func writeConfigErrors(w io.Writer, err error, v *validator.Validator) {
+	names := make([]string, 0, len(v.Errors))
+	for name := range v.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "invalid configuration:")
+	if err != nil {
+		// errors.Join() puts each error on its own line.
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %s\n", name, v.Errors[name])
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
 	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/logging"
 )
 
 // Define a custom contextKey type, with the underlying type string.
@@ -15,12 +17,65 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The scopesContextKey holds the permission codes that the credential used for the
+// current request is limited to. It is only set for scoped credentials such as API
+// keys; requests authenticated with a normal token are not restricted.
+const scopesContextKey = contextKey("scopes")
+
+// The requestStateContextKey holds a *requestState. Middleware near the top of the chain
+// adds it, so that it can see what was worked out further down the chain once the
+// request has been served.
+const requestStateContextKey = contextKey("requestState")
+
+// requestState is filled in as the request passes through the middleware and router.
+type requestState struct {
+	requestID string
+	user      *data.User
+	// route is the method and pattern of the route which matched, such as
+	// "GET /v1/movie/:id", or empty if none did. pattern is the pattern on its own,
+	// for labels which already have the method alongside.
+	route   string
+	pattern string
+}
+
+// The contextSetRequestState() method returns a new copy of the request with an empty
+// requestState added to the context, along with the requestState itself.
+func (app *application) contextSetRequestState(r *http.Request) (*http.Request, *requestState) {
+
+	state := &requestState{}
+	ctx := context.WithValue(r.Context(), requestStateContextKey, state)
+	return r.WithContext(ctx), state
+}
+
+// The contextGetRequestState() method returns the requestState for the request, or nil
+// if the request didn't pass through contextSetRequestState().
+func (app *application) contextGetRequestState(r *http.Request) *requestState {
+
+	state, _ := r.Context().Value(requestStateContextKey).(*requestState)
+	return state
+}
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
+//
+// The user's ID, and the impersonator's if there is one, is also attached to the
+// context for logging.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 
 	ctx := context.WithValue(r.Context(), userContextKey, user)
+
+	if state := app.contextGetRequestState(r); state != nil {
+		state.user = user
+	}
+
+	if !user.IsAnonymous() {
+		ctx = logging.With(ctx, slog.Int64("user_id", user.ID))
+	}
+	if user.Impersonator != nil {
+		ctx = logging.With(ctx, slog.Int64("impersonator_id", user.Impersonator.ID))
+	}
+
 	return r.WithContext(ctx)
 }
 
@@ -28,6 +83,9 @@ func (app *application) contextSetUser(r *http.Request, user *data.User) *http.R
 // time that we'll use this helper is when we logically expect there to be User struct
 // value in the context, and if it doesn't exist it will firmly be an 'unexpected' error.
 // As we discussed earlier in the book, it's OK to panic in those circumstances.
+//
+// During impersonation this is the impersonated user, and the administrator behind the
+// request is in its Impersonator field.
 func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	user, ok := r.Context().Value(userContextKey).(*data.User)
@@ -37,3 +95,20 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetScopes() method returns a new copy of the request with the permission
+// codes that the current credential is restricted to added to the context.
+func (app *application) contextSetScopes(r *http.Request, scopes data.Permissions) *http.Request {
+
+	ctx := context.WithValue(r.Context(), scopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+// The contextGetScopes() method returns the permission codes that the current credential
+// is restricted to. Unlike contextGetUser() a missing value is expected here, and the
+// second return value reports whether the request is restricted at all.
+func (app *application) contextGetScopes(r *http.Request) (data.Permissions, bool) {
+
+	scopes, ok := r.Context().Value(scopesContextKey).(data.Permissions)
+	return scopes, ok
+}
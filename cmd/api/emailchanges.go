@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// requestEmailChangeHandler starts an email change for the current user. The new
+// address is only stored as pending; the user keeps logging in with their old address
+// until the change is confirmed from the new one.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from your current email address")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.verifyCurrentPassword(w, r, user, input.Password, "password") {
+		return
+	}
+
+	// Check up front whether the address is taken, so that we don't send a
+	// confirmation email which can never succeed. The check is repeated when the
+	// change is confirmed, because the address could be claimed in the meantime.
+	_, err = app.modelsFor(r).User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).EmailChanges.Set(user.ID, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Starting a new change invalidates the tokens sent for any earlier one.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailChangeCancel} {
+		err = app.modelsFor(r).Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	confirmToken, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cancelToken, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChangeCancel)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"name":             user.Name,
+			"emailChangeToken": confirmToken.Plaintext,
+		}
+
+		err := app.mailer.Send(r.Context(), input.Email, "email_change_confirm.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.background(func() {
+		data := map[string]any{
+			"name":        user.Name,
+			"newEmail":    input.Email,
+			"cancelToken": cancelToken.Plaintext,
+		}
+
+		err := app.mailer.Send(r.Context(), user.Email, "email_change_notice.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "a confirmation email has been sent to the new address"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler swaps in the pending email address once its owner has
+// proved that they control it.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).User.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	change, err := app.modelsFor(r).EmailChanges.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Email = change.Email
+
+	// The UNIQUE constraint on users.email is the final word on whether the address
+	// is still free, and UpdateUser() maps a violation to ErrDuplicateEmail.
+	err = app.modelsFor(r).User.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.finishEmailChange(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelEmailChangeHandler lets the owner of the old address stop a change which they
+// didn't ask for. Since that suggests somebody else has access to the account, it also
+// signs the account out everywhere.
+func (app *application) cancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).User.GetForToken(data.ScopeEmailChangeCancel, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.finishEmailChange(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "email change successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// finishEmailChange() discards a user's pending email change along with both of the
+// tokens that were sent for it.
+func (app *application) finishEmailChange(r *http.Request, userID int64) error {
+	err := app.modelsFor(r).EmailChanges.Delete(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopeEmailChangeCancel} {
+		err = app.modelsFor(r).Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,14 +2,21 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
 )
 
+// logError() logs an error which is about to be turned into a 500 response. The stack
+// trace is included, since the error message alone rarely says where things went
+// wrong, and the request's context adds the request ID, user ID, method and path.
 func (app *application) logError(
-	_ *http.Request,
+	r *http.Request,
 	err error,
 ) {
-	app.logger.Println(err)
+	app.logger.ErrorContext(r.Context(), err.Error(), "trace", string(debug.Stack()))
 }
 
 func (app *application) errorResponse(
@@ -20,6 +27,12 @@ func (app *application) errorResponse(
 ) {
 	env := envelope{"error": message}
 
+	// Include the request ID, so that it can be quoted when reporting the error and
+	// matched up with the logs.
+	if state := app.contextGetRequestState(r); state != nil {
+		env["request_id"] = state.requestID
+	}
+
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -56,12 +69,80 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// invalid api key response
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // invalid credentials
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// too many login attempts. The Retry-After header tells the client how many seconds to
+// wait before trying again.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// rate limit exceeded. As with tooManyLoginAttemptsResponse(), the Retry-After header
+// tells the client how many seconds to wait.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// oauthErrorResponse() sends an error from one of the OAuth endpoints. OAuth client
+// libraries expect the error format from RFC 6749 section 5.2, with a machine-readable
+// code in "error", rather than our usual envelope.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	env := envelope{"error": code, "error_description": description}
+
+	err := app.writeJSON(w, status, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// invalid OAuth client credentials
+func (app *application) invalidClientResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
+
+// a write attempted with an impersonation token
+func (app *application) impersonationReadOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "changes are not allowed while impersonating a user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// missing or wrong credentials for the metrics endpoint
+func (app *application) metricsAuthRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+
+	message := "you must provide valid credentials to access the metrics"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// a signup attempted while public signup is disabled
+func (app *application) signupDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "public signup is disabled, please ask an administrator for an invitation"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/vcs"
+	"github.com/ridwanulhoquejr/lets-go-further/migrations"
 )
 
 func (app *application) healthCheckHandler(
@@ -9,18 +15,150 @@ func (app *application) healthCheckHandler(
 	r *http.Request,
 ) {
 
+	build := vcs.Get()
+
 	// Create a map which holds the information that we want to send in the response.
 	env := envelope{
 		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
+		"system_info": map[string]any{
+			"environment": app.config().env,
+			"version":     build.Version,
+			"commit":      build.Commit,
+			"build_time":  build.BuildTime,
+			"go_version":  build.GoVersion,
+			"modified":    build.Modified,
 		},
 	}
 
 	err := app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
-		app.logger.Println(err)
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// versionHandler() describes the build which is serving the request, so that it's easy
+// to tell exactly what is deployed.
+func (app *application) versionHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"build": vcs.Get()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// livenessHandler() reports that the process is up and able to serve requests. It
+// doesn't check any dependencies, because restarting the API won't fix a database
+// outage, and it keeps succeeding during graceful shutdown.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler() reports whether the API should be sent traffic. It checks that
+// graceful shutdown hasn't begun, that the database can be reached and has the schema
+// this build expects, and optionally that the SMTP server accepts our credentials. The
+// result of every check is included, and the response is a 503 if any of them failed.
+//
+// The detail of a failure, which could mention internal host names, is logged rather
+// than included in the response.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := app.config()
+	models := app.modelsFor(r)
+
+	ready := true
+	checks := make(map[string]any)
+
+	fail := func(name string, err error, details envelope) {
+		ready = false
+		app.logger.Warn("readiness check failed", "check", name, "error", err)
+		details["status"] = "failing"
+		checks[name] = details
+	}
+
+	if app.shuttingDown.Load() {
+		fail("shutdown", errors.New("graceful shutdown has begun"), envelope{})
+	} else {
+		checks["shutdown"] = envelope{"status": "ok"}
+	}
+
+	// Report how busy the connection pool is, whether or not the database could be
+	// reached. A saturated pool means queries are waiting for a connection.
+	stats := models.Database.Stats()
+
+	saturation := 0
+	if stats.MaxOpenConnections > 0 {
+		saturation = 100 * stats.InUse / stats.MaxOpenConnections
+	}
+
+	pool := envelope{
+		"open_connections":   stats.OpenConnections,
+		"in_use":             stats.InUse,
+		"idle":               stats.Idle,
+		"max_open":           stats.MaxOpenConnections,
+		"wait_count":         stats.WaitCount,
+		"wait_duration_ms":   stats.WaitDuration.Milliseconds(),
+		"saturated":          stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections,
+		"saturation_percent": saturation,
+	}
+
+	err := models.Database.Ping(cfg.health.timeout)
+	if err != nil {
+		fail("database", err, envelope{"pool": pool})
+	} else {
+		checks["database"] = envelope{"status": "ok", "pool": pool}
+	}
+
+	// The migrations are applied separately with the migrate tool, so make sure they
+	// have been, and that none of them failed part way through. There is no way to tell
+	// without the database.
+	if err != nil {
+		checks["migrations"] = envelope{"status": "skipped"}
+	} else {
+		expected, err := migrations.Latest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		details := envelope{"expected_version": expected}
+
+		version, dirty, err := models.Database.SchemaVersion(cfg.health.timeout)
+		switch {
+		case err != nil:
+			fail("migrations", err, details)
+		case dirty:
+			details["version"] = version
+			fail("migrations", fmt.Errorf("migration %d is dirty", version), details)
+		case version != expected:
+			details["version"] = version
+			fail("migrations", fmt.Errorf("schema is at version %d, expected %d", version, expected), details)
+		default:
+			details["version"] = version
+			details["status"] = "ok"
+			checks["migrations"] = details
+		}
+	}
+
+	if cfg.health.smtp {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.health.timeout)
+		defer cancel()
+
+		err := app.mailer.Ping(ctx)
+		if err != nil {
+			fail("smtp", err, envelope{})
+		} else {
+			checks["smtp"] = envelope{"status": "ok"}
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	err = app.writeJSON(w, code, envelope{"status": status, "checks": checks}, nil)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"runtime/debug"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
 	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
 )
 
@@ -21,16 +27,18 @@ func (app *application) background(fn func()) {
 
 	// Increment the WaitGroup counter.
 	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
 
 	// launch a go routine
 	go func() {
 
 		// Use defer to decrement the WaitGroup counter before the goroutine returns.
 		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Println(err)
+				app.logger.Error(fmt.Sprint(err), "trace", string(debug.Stack()))
 			}
 		}()
 
@@ -56,7 +64,7 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	// Extract the value from the query string.
 	csv := qs.Get(key)
 
-	app.logger.Printf("query Genres are: %s", csv)
+	app.logger.Debug("query genres", "genres", csv)
 
 	if csv == "" {
 		return defaultValue
@@ -88,6 +96,25 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readBool() helper reads an optional boolean value from the query string. It
+// returns nil if no matching key could be found, so that callers can tell "not
+// provided" apart from false. If the value couldn't be converted to a boolean, then we
+// record an error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
 // ! readJSON helper
 func (app *application) readJSON(
 	w http.ResponseWriter,
@@ -217,3 +244,131 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	}
 	return id, nil
 }
+
+// clientIP() returns the IP address of the client that made the request. Requests which
+// arrive through one of the trusted proxies carry the client's address in the
+// X-Forwarded-For header, which each proxy appends to. The header is read from the right,
+// skipping any trusted proxies, and the first other address is the client. Anything to
+// the left of that was sent by the client itself, so can't be trusted.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			// The header is garbled, so fall back to the last address we could trust.
+			return ip
+		}
+
+		ip = addr.Unmap().String()
+		if !app.isTrustedProxy(ip) {
+			return ip
+		}
+	}
+
+	// Every hop was a trusted proxy, or there was no X-Forwarded-For header. A proxy
+	// may use X-Real-IP instead.
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return ip
+}
+
+// originTrusted() reports whether origin, from the Origin header, matches one of the
+// trusted origins. A trusted origin such as "https://*.example.com" matches any
+// subdomain of example.com, but not example.com itself.
+func originTrusted(origin string, trusted []string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range trusted {
+		if origin == pattern {
+			return true
+		}
+
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if !ok {
+			continue
+		}
+
+		host, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+
+		subdomain, ok := strings.CutSuffix(host, "."+domain)
+		if ok && subdomain != "" && !strings.ContainsAny(subdomain, ":/@") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTrustedProxy() reports whether the IP address belongs to one of the trusted proxies.
+func (app *application) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range app.config().trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRequestID() returns a random ID for a request, as 32 hex characters.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// validRequestID() reports whether a request ID sent by a client is safe to use. IDs are
+// echoed back and written to the logs, so only short IDs made up of letters, digits and
+// a little punctuation are accepted.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// modelsFor() returns the models to use while serving r, whose queries are traced as
+// part of the request.
+func (app *application) modelsFor(r *http.Request) *data.Models {
+	return app.models.WithContext(r.Context())
+}
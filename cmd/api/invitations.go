@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// invitationTTL is how long an invitee has to accept their invitation.
+const invitationTTL = 7 * 24 * time.Hour
+
+// createInvitationHandler invites someone to create an account, with the given
+// permission codes. Inviting an address again replaces the earlier invitation.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.modelsFor(r).Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range input.Permissions {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("%q is not a known permission", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.modelsFor(r).User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	// There is no user to target until the invitation is accepted, which is when
+	// Accept() links this entry to them.
+	audit := app.auditEntry(r, 0, data.AuditUserInvited, map[string]any{
+		"email":       input.Email,
+		"permissions": input.Permissions,
+	})
+
+	invitation, err := app.modelsFor(r).Invitations.New(input.Email, admin.ID, input.Permissions, invitationTTL, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"inviterName":     admin.Name,
+			"invitationToken": invitation.Plaintext,
+		}
+
+		err := app.mailer.Send(r.Context(), invitation.Email, "user_invitation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler creates the invitee's account. They choose their name and
+// password, while the email address and permissions come from the invitation. The
+// account is activated straight away, because the token was sent to that address.
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Name           string `json:"name"`
+		Password       string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	invitation, err := app.modelsFor(r).Invitations.GetForToken(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := &data.User{
+		Name:  input.Name,
+		Email: invitation.Email,
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateUser(v, user)
+
+	err = app.passwordPolicy.Check(v, "password", input.Password, user.Email, user.Name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Invitations.Accept(input.TokenPlaintext, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/tracing"
+)
+
+// deletionBatchSize is the most accounts that one run of the deletion job removes.
+// Anything left over is picked up by the next run.
+const deletionBatchSize = 100
+
+// limiterCleanupInterval is how often the rate limiter forgets the clients that have
+// gone quiet.
+const limiterCleanupInterval = time.Minute
+
+// startJobs() launches the periodic background jobs. They stop when ctx is cancelled,
+// which serve() does once shutdown begins.
+func (app *application) startJobs(ctx context.Context) {
+	app.runJob(ctx, "account deletion", app.config().deletion.interval, app.deleteDueAccounts)
+	app.runJob(ctx, "rate limiter cleanup", limiterCleanupInterval, app.cleanupRateLimiter)
+}
+
+// runJob() calls fn every interval until ctx is cancelled. The goroutine is tracked by
+// the application WaitGroup, so that a run which is part way through can finish
+// before the application exits.
+func (app *application) runJob(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+
+	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							app.logger.Error("background job panicked", "job", name, "error", err, "trace", string(debug.Stack()))
+						}
+					}()
+
+					// Each run is a trace of its own.
+					ctx, span := tracing.Start(ctx, "job "+name)
+					defer span.End()
+
+					err := fn(ctx)
+					if err != nil {
+						span.RecordError(err)
+						app.logger.Error("background job failed", "job", name, "error", err)
+					}
+				}()
+			}
+		}
+	}()
+}
+
+// deleteDueAccounts() removes the accounts whose deletion grace period has ended.
+// Everything that belongs to an account goes with it through the foreign keys: tokens,
+// keys, permission grants, OAuth clients and so on are deleted, while movies that the
+// user created are kept but no longer point at them. Their audit entries are kept too,
+// with any personal details stripped out. Each user is emailed once their account is
+// gone.
+func (app *application) deleteDueAccounts(ctx context.Context) error {
+
+	models := app.models.WithContext(ctx)
+
+	deletions, err := models.Deletions.GetDue(ctx, deletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, deletion := range deletions {
+		// Stop between accounts if the application is shutting down.
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// A problem with one account is logged and skipped, rather than holding up
+		// the rest of the batch. Its deletion stays due, so it is tried again on the
+		// next run.
+		user, err := models.User.Get(deletion.UserID)
+		if err != nil {
+			app.logger.Error("cannot delete user", "user_id", deletion.UserID, "error", err)
+			continue
+		}
+
+		err = models.Deletions.Complete(user.ID)
+		if err != nil {
+			app.logger.Error("cannot delete user", "user_id", user.ID, "error", err)
+			continue
+		}
+
+		app.logger.Info("deleted user at their request", "user_id", user.ID)
+
+		app.background(func() {
+			err := app.mailer.Send(ctx, user.Email, "account_deleted.tmpl", map[string]any{"name": user.Name})
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	return nil
+}
+
+// cleanupRateLimiter() drops the rate limiter buckets which have refilled, so that the
+// limiter doesn't keep a bucket for every client that has ever made a request.
+func (app *application) cleanupRateLimiter(ctx context.Context) error {
+	app.limiter.Cleanup()
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+)
+
+// Email addresses are stored as citext, so the lockout key has to ignore case in the
+// same way or an attacker could reset the count by changing the case of a letter.
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func (app *application) ipLockoutKey(r *http.Request) string {
+	return "ip:" + app.clientIP(r)
+}
+
+// checkLoginAllowed() returns how long the client must wait before it may attempt to
+// log in to the account with the given email. A zero duration means that the attempt
+// can go ahead.
+func (app *application) checkLoginAllowed(r *http.Request, email string) (time.Duration, error) {
+	ipWait, err := app.ipLockout.Check(app.ipLockoutKey(r))
+	if err != nil {
+		return 0, err
+	}
+
+	accountWait, err := app.accountLockout.Check(accountLockoutKey(email))
+	if err != nil {
+		return 0, err
+	}
+
+	return max(ipWait, accountWait), nil
+}
+
+// recordLoginFailure() counts a failed login against the client IP and, if the account
+// exists, against the account too. If this failure locks the account, its owner is sent
+// an email in the background.
+func (app *application) recordLoginFailure(r *http.Request, user *data.User) error {
+	_, err := app.ipLockout.Fail(app.ipLockoutKey(r))
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return nil
+	}
+
+	locked, err := app.accountLockout.Fail(accountLockoutKey(user.Email))
+	if err != nil {
+		return err
+	}
+
+	if locked {
+		app.logger.WarnContext(r.Context(), "account locked after too many failed logins", "locked_user_id", user.ID)
+
+		lockedUntil := time.Now().Add(app.config().login.lockoutDuration)
+
+		app.background(func() {
+			data := map[string]any{
+				"name":        user.Name,
+				"lockedUntil": lockedUntil.Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(r.Context(), user.Email, "account_locked.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	return nil
+}
+
+// recordLoginSuccess() clears the failed logins recorded against an account. The
+// per-IP count is left alone, otherwise an attacker who controls one account could use
+// it to reset the limit for their IP address.
+func (app *application) recordLoginSuccess(user *data.User) error {
+	return app.accountLockout.Reset(accountLockoutKey(user.Email))
+}
+
+// unlockUserHandler lets an administrator clear a lockout before it expires.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	// The lockout isn't stored in the database, so it can't be cleared in the same
+	// transaction as the audit entry is written. Write the entry first, so that an
+	// unlock is never left unaudited.
+	err := app.modelsFor(r).Audit.Insert(app.auditEntry(r, user.ID, data.AuditUserUnlocked, nil))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordLoginSuccess(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
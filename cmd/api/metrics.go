@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/metrics"
+)
+
+// appMetrics holds the metrics served at GET /metrics.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight atomic.Int64
+}
+
+// newMetrics() registers the application's metrics. Most of them are read when
+// Prometheus scrapes the endpoint, from the database pool, the mailer and the Go
+// runtime; only the HTTP request metrics are updated as requests are served.
+func (app *application) newMetrics(db *sql.DB) *appMetrics {
+
+	m := &appMetrics{registry: metrics.NewRegistry()}
+	reg := m.registry
+
+	// Requests are labelled with their route pattern, such as /v1/movie/:id, rather
+	// than the path, so that the number of time series stays bounded.
+	m.requests = reg.NewCounterVec("http_requests_total", "Total HTTP requests served.", "method", "route", "status")
+	m.duration = reg.NewHistogramVec("http_request_duration_seconds", "HTTP request latency in seconds.", metrics.DefBuckets, "method", "route")
+	reg.NewGaugeFunc("http_requests_in_flight", "HTTP requests currently being served.", func() float64 {
+		return float64(m.inFlight.Load())
+	})
+
+	reg.NewGaugeFunc("background_tasks_in_flight", "Background goroutines currently running, such as email sends and periodic jobs.", func() float64 {
+		return float64(app.backgroundTasks.Load())
+	})
+
+	reg.NewCounterFunc("mailer_sent_total", "Emails sent successfully.", func() float64 {
+		return float64(app.mailer.Sent())
+	})
+	reg.NewCounterFunc("mailer_failed_total", "Emails which could not be sent after retrying.", func() float64 {
+		return float64(app.mailer.Failed())
+	})
+
+	// The connection pool configured in openDB().
+	dbStat := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	reg.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.", dbStat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	reg.NewGaugeFunc("db_open_connections", "Open database connections, in use or idle.", dbStat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	reg.NewGaugeFunc("db_in_use_connections", "Database connections currently in use.", dbStat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	reg.NewGaugeFunc("db_idle_connections", "Idle database connections.", dbStat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	reg.NewCounterFunc("db_wait_count_total", "Times a query waited for a free database connection.", dbStat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	reg.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for a free database connection.", dbStat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	reg.NewCounterFunc("db_max_idle_closed_total", "Connections closed because of db-max-idle-conns.", dbStat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	reg.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed because of db-max-idle-time.", dbStat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+
+	// Go runtime statistics.
+	reg.NewGaugeFunc("go_goroutines", "Number of goroutines.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	memStat := func(fn func(*runtime.MemStats) float64) func() float64 {
+		return func() float64 {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			return fn(&ms)
+		}
+	}
+	reg.NewGaugeFunc("go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects.", memStat(func(ms *runtime.MemStats) float64 { return float64(ms.HeapAlloc) }))
+	reg.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", memStat(func(ms *runtime.MemStats) float64 { return float64(ms.HeapInuse) }))
+	reg.NewGaugeFunc("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", memStat(func(ms *runtime.MemStats) float64 { return float64(ms.Sys) }))
+	reg.NewCounterFunc("go_gc_cycles_total", "Completed garbage collection cycles.", memStat(func(ms *runtime.MemStats) float64 { return float64(ms.NumGC) }))
+	reg.NewCounterFunc("go_gc_pause_seconds_total", "Total time spent in garbage collection pauses.", memStat(func(ms *runtime.MemStats) float64 { return time.Duration(ms.PauseTotalNs).Seconds() }))
+
+	return m
+}
+
+// addPermissionCache() adds the counters of the permission cache to the metrics.
+func (m *appMetrics) addPermissionCache(cache *data.PermissionCache) {
+	reg := m.registry
+
+	stat := func(fn func(data.PermissionCacheStats) float64) func() float64 {
+		return func() float64 { return fn(cache.Stats()) }
+	}
+	reg.NewCounterFunc("permission_cache_hits_total", "Permission lookups answered from the cache.", stat(func(s data.PermissionCacheStats) float64 { return float64(s.Hits) }))
+	reg.NewCounterFunc("permission_cache_misses_total", "Permission lookups which had to query the database.", stat(func(s data.PermissionCacheStats) float64 { return float64(s.Misses) }))
+	reg.NewCounterFunc("permission_cache_evictions_total", "Permission cache entries dropped to make room for new ones.", stat(func(s data.PermissionCacheStats) float64 { return float64(s.Evictions) }))
+	reg.NewGaugeFunc("permission_cache_entries", "Users whose permissions are cached.", stat(func(s data.PermissionCacheStats) float64 { return float64(s.Entries) }))
+}
+
+// recordMetrics() counts every request and records how long it took.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.inFlight.Add(1)
+		defer app.metrics.inFlight.Add(-1)
+
+		mw := newMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		method, route := r.Method, "unmatched"
+		if state := app.contextGetRequestState(r); state != nil && state.pattern != "" {
+			route = state.pattern
+		} else if !knownMethods[method] {
+			// The method of a request that didn't match a route could be anything,
+			// and every distinct value would be a new time series.
+			method = "other"
+		}
+
+		app.metrics.requests.Inc(method, route, strconv.Itoa(mw.statusCode))
+		app.metrics.duration.Observe(time.Since(start).Seconds(), method, route)
+	})
+}
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
@@ -1,48 +1,468 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/logging"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/policy"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/ratelimit"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/tracing"
 	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
 )
 
-// CORS middleware
+// requestContext() gives every request an ID, which is sent back in the X-Request-ID
+// header and in error responses so that a user reporting a problem can quote it. If a
+// client or proxy has already given the request an ID in the X-Request-ID header, that
+// ID is kept, so the request can be followed from one service to the next. The ID,
+// method and path are attached to the request context, so that they appear on every log
+// line written while serving the request.
+func (app *application) requestContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := logging.With(r.Context(),
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		)
+
+		r, state := app.contextSetRequestState(r.WithContext(ctx))
+		state.requestID = id
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceRequest() records a span for each request, which the spans for its queries and
+// emails become children of. If the client sent a valid traceparent header, the span
+// continues the client's trace. The trace ID is attached to the request context for
+// logging, so that log lines and spans can be matched up, and the span is sent back in
+// a traceparent response header, so that a client can look up the trace for a response.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if parent, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		// The span is renamed after the route once the router has matched one.
+		ctx, span := tracing.Start(ctx, r.Method)
+		defer span.End()
+
+		if span != nil {
+			ctx = logging.With(ctx, slog.String("trace_id", span.SpanContext().TraceID.String()))
+			w.Header().Set("traceparent", span.SpanContext().Traceparent())
+		}
+
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+
+		r = r.WithContext(ctx)
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		span.SetAttr("http.status_code", mw.statusCode)
+		if mw.statusCode >= 500 {
+			span.RecordError(errors.New(http.StatusText(mw.statusCode)))
+		}
+
+		if state := app.contextGetRequestState(r); state != nil {
+			span.SetAttr("request_id", state.requestID)
+			if state.route != "" {
+				span.SetName(state.route)
+				span.SetAttr("http.route", state.pattern)
+			}
+			if state.user != nil && !state.user.IsAnonymous() {
+				span.SetAttr("user_id", state.user.ID)
+			}
+		}
+	})
+}
+
+// redactedHeaders are the request headers whose values are never written to the access
+// log, because they carry credentials.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// accessLog() writes one log line for each request, with its status, size and how long
+// it took. Only a sample of requests is logged if access-log-sample is below 1, but
+// server errors and requests slower than access-log-slow are always logged, and slow
+// requests are logged at warn level.
+func (app *application) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		duration := time.Since(start)
+		state := app.contextGetRequestState(r)
+		slow := app.config().accessLog.slow > 0 && duration >= app.config().accessLog.slow
+
+		if !slow && mw.statusCode < http.StatusInternalServerError && rand.Float64() >= app.config().accessLog.sample {
+			return
+		}
+
+		headers := r.Header.Clone()
+		for _, name := range redactedHeaders {
+			if headers.Get(name) != "" {
+				headers.Set(name, "[REDACTED]")
+			}
+		}
+
+		attrs := []slog.Attr{
+			slog.String("route", state.route),
+			slog.Int("status", mw.statusCode),
+			slog.Int64("bytes", mw.bytesWritten),
+			slog.Duration("duration", duration),
+			slog.String("remote_ip", app.clientIP(r)),
+			slog.String("query", r.URL.RawQuery),
+			slog.Any("headers", headers),
+		}
+
+		// authenticate() and the router run after this middleware, so the user and
+		// route are only known through the request state.
+		if state.user != nil && !state.user.IsAnonymous() {
+			attrs = append(attrs, slog.Int64("user_id", state.user.ID))
+		}
+
+		level := slog.LevelInfo
+		message := "request completed"
+		if slow {
+			level = slog.LevelWarn
+			message = "slow request"
+		}
+
+		app.logger.LogAttrs(r.Context(), level, message, attrs...)
+	})
+}
+
+// metricsResponseWriter wraps a http.ResponseWriter to record the status code and the
+// number of bytes written.
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int64
+	headerWritten bool
+}
+
+func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
+	return &metricsResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += int64(n)
+	return n, err
+}
+
+// Flush() and Hijack() pass through to the wrapped writer, so that wrapping it doesn't
+// stop handlers from streaming or taking over the connection.
+func (mw *metricsResponseWriter) Flush() {
+	if flusher, ok := mw.wrapped.(http.Flusher); ok {
+		mw.headerWritten = true
+		flusher.Flush()
+	}
+}
+
+func (mw *metricsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := mw.wrapped.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the wrapped ResponseWriter does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap() lets http.ResponseController reach the wrapped writer.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// requireMetricsAuth() checks the HTTP basic authentication credentials that Prometheus
+// sends when it scrapes GET /metrics.
+func (app *application) requireMetricsAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+
+		// Compare hashes so that the comparison takes the same time whatever the
+		// lengths of the values.
+		usernameHash := sha256.Sum256([]byte(username))
+		passwordHash := sha256.Sum256([]byte(password))
+		expectedUsernameHash := sha256.Sum256([]byte(app.config().metrics.username))
+		expectedPasswordHash := sha256.Sum256([]byte(app.config().metrics.password))
+
+		usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+		passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+
+		if !ok || !usernameMatch || !passwordMatch {
+			app.metricsAuthRequiredResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// The request headers that browsers may send cross-origin, the methods that they may use,
+// and the response headers that scripts may read, in addition to those that CORS always
+// allows.
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, X-API-Key, X-Expected-Version, X-Request-ID, traceparent"
+	corsExposedHeaders = "Content-Disposition, Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After, WWW-Authenticate, X-Request-ID, traceparent"
+)
+
+// enableCORS() lets web pages served from the trusted origins call the API from the
+// browser. Requests from any other origin get no CORS headers at all, so the browser
+// keeps their responses from the page.
+//
+// Because our API needs the Authorization header, browsers first send a preflight
+// request: an OPTIONS request with an Access-Control-Request-Method header, asking
+// whether the real request is allowed. Preflight requests from trusted origins are
+// answered here, without going any further down the chain.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		// this will enable all the other origin to recieve data
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// The response depends on these request headers, so any cache between us and the
+		// browser has to take them into account.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		origin := r.Header.Get("Origin")
+		cfg := app.config()
+
+		if origin != "" && originTrusted(origin, cfg.cors.trustedOrigins) {
+			// Echo the origin back rather than using "*", which browsers don't accept
+			// for requests with credentials.
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.cors.maxAge.Seconds())))
+
+				// Some older browsers don't treat 204 No Content as a successful
+				// preflight response, so send 200 OK.
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRoute() records which route matched the request, for the access log and metrics.
+func (app *application) setRoute(method, pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if state := app.contextGetRequestState(r); state != nil {
+			state.route = method + " " + pattern
+			state.pattern = pattern
+		}
+		next(w, r)
+	}
+}
+
+// limitClientIP() limits each client IP address before the request is authenticated.
+// authenticate() looks the token or API key up in the database, so without this a
+// client could send any number of made-up credentials, or flood the API with a valid
+// one, and the database would do the work before rateLimit() refused anything. The
+// limit is generous, since several users can share an address, and rateLimit() still
+// applies the per-user and per-route limits afterwards. The routes registered with
+// handleUnlimited() in routes() aren't limited here either.
+func (app *application) limitClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.config()
+
+		if !cfg.limiter.enabled || app.unlimitedRoutes[r.Method+" "+r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The RateLimit-* headers are left to rateLimit(), which describes the bucket
+		// that a well-behaved client is most likely to run out of.
+		res := app.limiter.Allow("client ip:"+app.clientIP(r), cfg.limiter.ip)
+		if !res.Allowed {
+			app.rateLimitExceededResponse(w, r, res.RetryAfter)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// rateLimit() limits how often each client may call a route. Authenticated users are
+// limited by their user ID, so that users sharing an IP address don't use up each
+// other's allowance, and anonymous requests by their client IP. A route with a
+// limiter-route override gets its own buckets with its own limit; every other route
+// shares one bucket per client.
+//
+// The RateLimit-* headers are sent with every response, so that well-behaved clients
+// can slow down before they are refused.
+func (app *application) rateLimit(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The limits are looked up for every request, because they can be reloaded.
+		cfg := app.config()
+
+		if !cfg.limiter.enabled {
+			next(w, r)
+			return
+		}
+
+		var key string
+		var limit ratelimit.Limit
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			key = "ip:" + app.clientIP(r)
+			limit = cfg.limiter.anonymous
+		} else {
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+			limit = cfg.limiter.user
+		}
+
+		override, hasOverride := cfg.limiter.routes[route]
+
+		if hasOverride {
+			key = route + " " + key
+			limit = override
+		}
+
+		res := app.limiter.Allow(key, limit)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+
+		if !res.Allowed {
+			app.rateLimitExceededResponse(w, r, res.RetryAfter)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
 // Note that the first parameter for the middleware function is the permission code that
 // we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAnyPermission([]string{code}, next)
+}
+
+// requireAnyPermission() lets the request through if the user holds at least one of the
+// permission codes. It's used in front of handlers which make a finer-grained decision
+// themselves once they've loaded the resource, such as those using a policy.Rule.
+func (app *application) requireAnyPermission(codes []string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
-		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// Get the slice of permissions that this request can use.
+		permissions, err := app.effectivePermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		// Check if the slice includes the required permission. If it doesn't, then
-		// return a 403 Forbidden response.
-		if !permissions.Include(code) {
+		// Check if the slice includes any of the required permissions. If it doesn't,
+		// then return a 403 Forbidden response.
+		for _, code := range codes {
+			if permissions.Include(code) {
+				// They have a required permission so we call the next handler in
+				// the chain.
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		app.notPermittedResponse(w, r)
+	}
+	// Wrap this with the requireActivatedUser() middleware before returning it.
+	return app.requireActivatedUser(fn)
+}
+
+// effectivePermissions() returns the permission codes that the current request may use.
+// If the request was made with a scoped credential such as an API key, these are the
+// user's permissions which the credential was also granted. Checking both means that
+// revoking a permission from the user also takes it away from all of their keys.
+func (app *application) effectivePermissions(r *http.Request) (data.Permissions, error) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, ok := app.contextGetScopes(r)
+	if !ok {
+		return permissions, nil
+	}
+
+	effective := data.Permissions{}
+	for _, code := range permissions {
+		if scopes.Include(code) {
+			effective = append(effective, code)
+		}
+	}
+
+	return effective, nil
+}
+
+// The requireUnscopedUser() middleware rejects requests made with a scoped credential.
+// It guards endpoints which manage credentials themselves, so that a leaked API key can't
+// be used to mint new keys.
+func (app *application) requireUnscopedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.contextGetScopes(r); ok {
 			app.notPermittedResponse(w, r)
 			return
 		}
-		// Otherwise they have the required permission so we call the next handler in
-		// the chain.
 		next.ServeHTTP(w, r)
 	}
-	// Wrap this with the requireActivatedUser() middleware before returning it.
+
 	return app.requireActivatedUser(fn)
 }
 
@@ -53,7 +473,6 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		//todo: this is not working, when it tried to get the user from context -> panic () missing user value in request context
 		if user.IsAnonymous() {
 			app.authenticationRequiredResponse(w, r)
 			return
@@ -84,6 +503,14 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// API keys may be sent in their own header, which is easier to configure in
+		// most CI systems than an Authorization header.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, next, apiKey)
+			return
+		}
 
 		// Get the value from the header
 		authorizatonHeader := r.Header.Get("Authorization")
@@ -93,18 +520,31 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// call the next handler in the chain and return without executing any of the
 		// code below.
 		if authorizatonHeader == "" {
-			app.contextSetUser(r, data.AnonymousUser)
+			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Otherwise, we expect the value of the Authorization header to be in the format
-		// "Bearer <token>". We try to split this into its constituent parts, and if the
-		// header isn't in the expected format we return a 401 Unauthorized response
-		// using the invalidAuthenticationTokenResponse() helper (which we will create
-		// in a moment).
+		// "Bearer <token>" or "ApiKey <key>". We try to split this into its constituent
+		// parts, and if the header isn't in the expected format we return a 401
+		// Unauthorized response using the invalidAuthenticationTokenResponse() helper.
 		headerParts := strings.Split(authorizatonHeader, " ")
 
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
+
+		// HTTP Basic authentication is only used by OAuth clients calling the token
+		// endpoints, which check the client credentials themselves. As far as users
+		// are concerned the request is anonymous.
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -112,6 +552,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		// OAuth access tokens are marked by their prefix.
+		if data.IsOAuthAccessToken(token) {
+			app.authenticateOAuthToken(w, r, next, token)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -119,7 +565,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.User.GetForToken(data.ScopeAuthentication, token)
+		user, authToken, err := app.modelsFor(r).User.GetForAuthenticationToken(token)
 
 		if err != nil {
 			switch {
@@ -131,6 +577,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if authToken.Scope == data.ScopeImpersonation {
+			app.authenticateImpersonation(w, r, next, user, authToken.ImpersonatorID)
+			return
+		}
+
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
@@ -141,6 +592,35 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey() finishes the authenticate() middleware for requests which carry
+// an API key. The key's owner becomes the request user, and the key's permissions are
+// added to the context so that effectivePermissions() can restrict the request to them.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	user, apiKey, err := app.modelsFor(r).APIKeys.GetForKey(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetScopes(r, apiKey.Permissions)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event of a panic
@@ -165,3 +645,95 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// allowedByPolicy() checks whether the current request may act on a resource which the
+// handler has loaded. If it may not, a 403 Forbidden response has already been sent,
+// and the caller should simply return.
+func (app *application) allowedByPolicy(w http.ResponseWriter, r *http.Request, rule policy.Rule, resource policy.Owned) bool {
+	permissions, err := app.effectivePermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !rule.Allows(app.contextGetUser(r).ID, permissions, resource) {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// authenticateOAuthToken() finishes the authenticate() middleware for requests which
+// carry an OAuth access token. The token acts for its user, limited to the permission
+// codes that the user consented to, in the same way as an API key.
+func (app *application) authenticateOAuthToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+
+	user, oauthToken, err := app.modelsFor(r).User.GetForOAuthToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetScopes(r, oauthToken.Permissions)
+
+	next.ServeHTTP(w, r)
+}
+
+// authenticateImpersonation() finishes the authenticate() middleware for requests which
+// carry an impersonation token. The request runs as the impersonated user, with the
+// administrator available as user.Impersonator. Impersonation is for looking, not
+// touching, so only safe methods are allowed, and every request is logged.
+func (app *application) authenticateImpersonation(w http.ResponseWriter, r *http.Request, next http.Handler, user *data.User, impersonatorID int64) {
+
+	impersonator, err := app.modelsFor(r).User.Get(impersonatorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Check the administrator's standing on every request rather than only when the
+	// token was minted, so that deactivating them or revoking their permission ends
+	// any impersonation straight away.
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(impersonator.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !impersonator.Activated || !permissions.Include("admin:impersonate") {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user.Impersonator = impersonator
+	r = app.contextSetUser(r, user)
+
+	// Every impersonated request is logged. The context adds the user and the
+	// impersonator.
+	app.logger.InfoContext(r.Context(), "impersonated request", "query", r.URL.RawQuery)
+
+	// Flag every response, so that a client which shows it can make clear that the
+	// session isn't the user's own.
+	w.Header().Set("X-Impersonated-By", strconv.FormatInt(impersonator.ID, 10))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		app.impersonationReadOnlyResponse(w, r)
+		return
+	}
+
+	next.ServeHTTP(w, r)
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/policy"
 	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
 )
 
+// movieWritePolicy decides who can change or delete a movie: anyone with movie:write,
+// or its creator if they have movie:write:own.
+var movieWritePolicy = policy.Rule{Any: "movie:write", Own: "movie:write:own"}
+
 // get muliple movie handler
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -54,7 +58,7 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 	// Dump the contents of the input struct in a HTTP response.
 	// fmt.Fprintf(w, "\n%+v\n", input)
-	movies, metadata, err := app.models.Movie.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.modelsFor(r).Movie.GetAll(input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -73,8 +77,6 @@ func (app *application) createMovieHandler(
 	r *http.Request,
 ) {
 
-	fmt.Println("Create new movie handler")
-
 	var input struct {
 		Title   string   `json:"title"`
 		Runtime int32    `json:"runtime"`
@@ -91,10 +93,11 @@ func (app *application) createMovieHandler(
 
 	// Copy the values from the input struct to a new Movie struct.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: app.contextGetUser(r).ID,
 	}
 
 	// Initialize a new Validator instance.
@@ -110,7 +113,7 @@ func (app *application) createMovieHandler(
 	// Call the Insert() method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
 	// movie struct with the system-generated information.
-	err = app.models.Movie.Insert(movie)
+	err = app.modelsFor(r).Movie.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -141,7 +144,7 @@ func (app *application) showMovieHandler(
 		return
 	}
 
-	movie, err := app.models.Movie.Get(id)
+	movie, err := app.modelsFor(r).Movie.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -149,14 +152,12 @@ func (app *application) showMovieHandler(
 			return
 		default:
 			app.serverErrorResponse(w, r, err)
-			app.logger.Printf("Error in movies handler: %s", err)
 			return
 		}
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
-		app.logger.Println(err)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -171,10 +172,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// get the movie from Get methtod using id that extracted above
-	movie, err := app.models.Movie.Get(id)
+	movie, err := app.modelsFor(r).Movie.Get(id)
 	if err != nil {
 		switch {
-		case errors.Is(sql.ErrNoRows, err):
+		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 			return
 		default:
@@ -183,6 +184,12 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	// requireAnyPermission() only checked that the user can change some movies, now
+	// check that this is one of them.
+	if !app.allowedByPolicy(w, r, movieWritePolicy, movie) {
+		return
+	}
+
 	// create payload input
 	var input struct {
 		Title   *string  `json:"title"`
@@ -226,7 +233,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// now we can proceed with actual update task
-	err = app.models.Movie.Update(movie)
+	err = app.modelsFor(r).Movie.Update(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -253,7 +260,22 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movie.Delete(id)
+	movie, err := app.modelsFor(r).Movie.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.allowedByPolicy(w, r, movieWritePolicy, movie) {
+		return
+	}
+
+	err = app.modelsFor(r).Movie.Delete(movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// This file implements an OAuth 2.0 authorization server (RFC 6749) so that third-party
+// clients can act for our users without ever seeing their passwords. It supports the
+// authorization code grant with PKCE (RFC 7636), which is mandatory, and the client
+// credentials grant, in which a confidential client acts for the user who registered
+// it. OAuth scopes are our permission codes, so "movie:read movie:write" asks for both.
+//
+// The access tokens are ordinary rows in the tokens table with the oauth-access scope,
+// and authenticate() restricts requests made with them to their permission codes in
+// the same way as for API keys.
+
+const (
+	oauthCodeTTL        = 10 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+)
+
+// createOAuthClientHandler registers a new client. The secret of a confidential client
+// is only ever shown in this response.
+func (app *application) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Permissions  []string `json:"permissions"`
+		Confidential *bool    `json:"confidential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	client := &data.OAuthClient{
+		UserID:       user.ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Permissions:  input.Permissions,
+		Confidential: true,
+	}
+
+	if input.Confidential != nil {
+		client.Confidential = *input.Confidential
+	}
+
+	granted, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateOAuthClient(v, client, granted); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).OAuthClients.Insert(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOAuthClientsHandler returns the clients registered by the current user.
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+
+	clients, err := app.modelsFor(r).OAuthClients.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOAuthClientHandler deletes one of the current user's clients, which revokes
+// every token issued to it.
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	err := app.modelsFor(r).OAuthClients.DeleteForUser(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authorizationRequest holds the parameters of an authorization request. The client's
+// web page sends the user to its own consent screen with these in the query string,
+// and the consent screen passes them on to the two authorize endpoints below.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// showAuthorizationHandler checks an authorization request and describes it, so that
+// the consent screen can ask the current user whether to approve it. The consented
+// field is true when the user has already agreed to every requested scope, in which
+// case the consent screen can approve the request without asking again.
+func (app *application) showAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	qs := r.URL.Query()
+
+	req := authorizationRequest{
+		ResponseType:        qs.Get("response_type"),
+		ClientID:            qs.Get("client_id"),
+		RedirectURI:         qs.Get("redirect_uri"),
+		Scope:               qs.Get("scope"),
+		State:               qs.Get("state"),
+		CodeChallenge:       qs.Get("code_challenge"),
+		CodeChallengeMethod: qs.Get("code_challenge_method"),
+	}
+
+	client, scopes, ok := app.checkAuthorizationRequest(w, r, req)
+	if !ok {
+		return
+	}
+
+	consented := false
+
+	existing, err := app.modelsFor(r).OAuthConsents.Get(app.contextGetUser(r).ID, client.ID)
+	switch {
+	case err == nil:
+		consented = true
+		for _, scope := range scopes {
+			consented = consented && existing.Include(scope)
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"client":       envelope{"client_id": client.ID, "name": client.Name},
+		"scopes":       scopes,
+		"redirect_uri": req.RedirectURI,
+		"consented":    consented,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// approveAuthorizationHandler records the current user's answer to an authorization
+// request. It responds with the URL to send the user back to, which carries either an
+// authorization code or an access_denied error for the client.
+func (app *application) approveAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		authorizationRequest
+		Approved bool `json:"approved"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	client, scopes, ok := app.checkAuthorizationRequest(w, r, input.authorizationRequest)
+	if !ok {
+		return
+	}
+
+	params := url.Values{}
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+
+	if !input.Approved {
+		params.Set("error", "access_denied")
+		app.writeRedirect(w, r, input.RedirectURI, params)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.modelsFor(r).OAuthConsents.Grant(user.ID, client.ID, scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	code, err := app.modelsFor(r).OAuthCodes.New(&data.OAuthCode{
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   input.RedirectURI,
+		Permissions:   scopes,
+		CodeChallenge: input.CodeChallenge,
+	}, oauthCodeTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	params.Set("code", code)
+	app.writeRedirect(w, r, input.RedirectURI, params)
+}
+
+// checkAuthorizationRequest() validates an authorization request for the current user.
+// It returns the client and the requested scopes. If the request isn't valid an error
+// response has already been sent, and the caller should simply return.
+//
+// Unlike most OAuth servers we never redirect with an error, because the consent screen
+// that calls us is the one that decides where to send the user.
+func (app *application) checkAuthorizationRequest(w http.ResponseWriter, r *http.Request, req authorizationRequest) (*data.OAuthClient, data.Permissions, bool) {
+
+	client, err := app.modelsFor(r).OAuthClients.Get(req.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "unknown client_id")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_response_type", "response_type must be code")
+		return nil, nil, false
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "a code_challenge with code_challenge_method S256 is required")
+		return nil, nil, false
+	}
+
+	scopes := data.Permissions(strings.Fields(req.Scope))
+	if len(scopes) == 0 {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "scope must be provided")
+		return nil, nil, false
+	}
+
+	// A client can only be given scopes that it registered for, and that the user has
+	// themselves.
+	granted, err := app.modelsFor(r).Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	for _, scope := range scopes {
+		if !client.Permissions.Include(scope) || !granted.Include(scope) {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "scope "+scope+" is not available")
+			return nil, nil, false
+		}
+	}
+
+	return client, scopes, true
+}
+
+// writeRedirect() responds with the URL that the consent screen should send the user
+// to, with the given parameters added to the redirect URI's query string.
+func (app *application) writeRedirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	qs := u.Query()
+	for key := range params {
+		qs.Set(key, params.Get(key))
+	}
+	u.RawQuery = qs.Encode()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_to": u.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// tokenHandler issues access tokens. Following RFC 6749, it reads a form-encoded body
+// and responds in the standard OAuth format rather than with our usual envelope.
+func (app *application) tokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !app.readOAuthForm(w, r) {
+		return
+	}
+
+	client, authenticated, ok := app.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		app.authorizationCodeGrant(w, r, client, authenticated)
+	case "client_credentials":
+		app.clientCredentialsGrant(w, r, client, authenticated)
+	default:
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or client_credentials")
+	}
+}
+
+func (app *application) authorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *data.OAuthClient, authenticated bool) {
+
+	// A confidential client must always prove who it is. A public client can't, and
+	// relies on PKCE alone.
+	if client.Confidential && !authenticated {
+		app.invalidClientResponse(w, r)
+		return
+	}
+
+	// The code is deleted by Consume() whatever happens next, so a code which fails
+	// any of the checks below can't be tried again.
+	code, err := app.modelsFor(r).OAuthCodes.Consume(r.PostForm.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+
+	if !verifyCodeChallenge(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	token, err := app.modelsFor(r).Tokens.NewOAuth(code.UserID, oauthAccessTokenTTL, client.ID, code.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeAccessToken(w, r, token)
+}
+
+// clientCredentialsGrant issues a token which acts for the user who registered the
+// client, limited to the client's permissions or the subset of them in the scope
+// parameter.
+func (app *application) clientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *data.OAuthClient, authenticated bool) {
+
+	if !authenticated {
+		app.invalidClientResponse(w, r)
+		return
+	}
+
+	scopes := client.Permissions
+	if scope := r.PostForm.Get("scope"); scope != "" {
+		scopes = strings.Fields(scope)
+		for _, s := range scopes {
+			if !client.Permissions.Include(s) {
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", "scope "+s+" is not available")
+				return
+			}
+		}
+	}
+
+	token, err := app.modelsFor(r).Tokens.NewOAuth(client.UserID, oauthAccessTokenTTL, client.ID, scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeAccessToken(w, r, token)
+}
+
+// verifyCodeChallenge() checks a PKCE code verifier against the S256 challenge that the
+// client sent with its authorization request.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// RFC 7636 requires verifiers of 43 to 128 characters.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func (app *application) writeAccessToken(w http.ResponseWriter, r *http.Request, token *data.Token) {
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	env := envelope{
+		"access_token": token.Plaintext,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(token.Expiry).Seconds()),
+		"scope":        strings.Join(token.Permissions, " "),
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// introspectHandler tells a confidential client whether one of its access tokens is
+// still active, and what it's for, as described in RFC 7662. Tokens issued to other
+// clients are reported as inactive.
+func (app *application) introspectHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !app.readOAuthForm(w, r) {
+		return
+	}
+
+	client, authenticated, ok := app.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	if !authenticated {
+		app.invalidClientResponse(w, r)
+		return
+	}
+
+	inactive := envelope{"active": false}
+
+	user, token, err := app.modelsFor(r).User.GetForOAuthToken(r.PostForm.Get("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.writeIntrospection(w, r, inactive)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if token.ClientID != client.ID || !user.Activated {
+		app.writeIntrospection(w, r, inactive)
+		return
+	}
+
+	app.writeIntrospection(w, r, envelope{
+		"active":     true,
+		"scope":      strings.Join(token.Permissions, " "),
+		"client_id":  token.ClientID,
+		"username":   user.Email,
+		"sub":        strconv.FormatInt(user.ID, 10),
+		"exp":        token.Expiry.Unix(),
+		"token_type": "Bearer",
+	})
+}
+
+func (app *application) writeIntrospection(w http.ResponseWriter, r *http.Request, env envelope) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeHandler lets a client revoke one of its access tokens, as described in RFC
+// 7009. The response is the same whether or not the token existed.
+func (app *application) revokeHandler(w http.ResponseWriter, r *http.Request) {
+
+	if !app.readOAuthForm(w, r) {
+		return
+	}
+
+	client, authenticated, ok := app.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	if client.Confidential && !authenticated {
+		app.invalidClientResponse(w, r)
+		return
+	}
+
+	err := app.modelsFor(r).Tokens.DeleteOAuth(r.PostForm.Get("token"), client.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// listOAuthConsentsHandler returns the clients that the current user has let act for
+// them.
+func (app *application) listOAuthConsentsHandler(w http.ResponseWriter, r *http.Request) {
+
+	consents, err := app.modelsFor(r).OAuthConsents.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"consents": consents}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOAuthConsentHandler withdraws the current user's consent for a client, which
+// also revokes the client's tokens for them.
+func (app *application) deleteOAuthConsentHandler(w http.ResponseWriter, r *http.Request) {
+
+	clientID := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	err := app.modelsFor(r).OAuthConsents.Delete(app.contextGetUser(r).ID, clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "consent successfully withdrawn"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOAuthForm() parses the form-encoded body used by the token, introspection and
+// revocation endpoints. If it can't be parsed an error response has already been sent,
+// and the caller should simply return.
+func (app *application) readOAuthForm(w http.ResponseWriter, r *http.Request) bool {
+
+	// Limit the size of the request body to 1MB, as readJSON() does.
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the body must be a valid form")
+		return false
+	}
+
+	return true
+}
+
+// authenticateOAuthClient() identifies the client making a request, from either HTTP
+// Basic authentication or the client_id and client_secret form fields. authenticated
+// reports whether the client proved who it is with its secret; public clients never
+// can. If the client can't be identified an error response has already been sent, and
+// the caller should simply return.
+func (app *application) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (client *data.OAuthClient, authenticated bool, ok bool) {
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 has clients form-encode the credentials before putting them in
+		// the Authorization header.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.modelsFor(r).OAuthClients.Get(clientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidClientResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false, false
+	}
+
+	if secret == "" {
+		return client, false, true
+	}
+
+	// A wrong secret is always an error, rather than being treated like no secret.
+	if !client.SecretMatches(secret) {
+		app.invalidClientResponse(w, r)
+		return nil, false, false
+	}
+
+	return client, true, true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// showCurrentUserHandler returns the authenticated user along with their roles and the
+// permission codes that they have been granted, either directly or through a role.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send an empty JSON array rather than null to users without any permissions.
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	roles, err := app.modelsFor(r).Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	// If the request contains a X-Expected-Version header, verify that the user
+	// version in the database matches the expected version specified in the header.
+	if r.Header.Get("X-Expected-Version") != "" {
+		if strconv.Itoa(user.Version) != r.Header.Get("X-Expected-Version") {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	// Only the name can be changed here. The email address is the user's login
+	// identity and the password needs the current password, so both have their own
+	// endpoints.
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// UpdateUser() only writes the record if its version hasn't changed since
+	// authenticate() read it, so concurrent updates can't silently overwrite each
+	// other.
+	err = app.modelsFor(r).User.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.verifyCurrentPassword(w, r, user, input.CurrentPassword, "current_password") {
+		return
+	}
+
+	// Check the new password before hashing it, and report any problem against the
+	// new_password field that it came from.
+	v := validator.New()
+
+	data.ValidatePasswordField(v, "new_password", input.NewPassword)
+
+	err = app.passwordPolicy.Check(v, "new_password", input.NewPassword, user.Email, user.Name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).User.UpdateUser(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sign the user out everywhere. Anyone who had the old password could have
+	// created a session with it, and the point of changing it is to lock them out.
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "password successfully updated, please log in again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyCurrentPassword() checks the password that the current user supplied to confirm
+// a sensitive change to their account. Checking a password is just another way to guess
+// it, so this is subject to the same lockout as logging in. If the check doesn't pass
+// an error response has already been sent, and the caller should simply return.
+func (app *application) verifyCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User, plaintext, field string) bool {
+
+	v := validator.New()
+	v.Check(plaintext != "", field, "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	wait, err := app.checkLoginAllowed(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return false
+	}
+
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !match {
+		err = app.recordLoginFailure(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		v.AddError(field, "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// handleReloads() reloads the configuration each time the process receives SIGHUP,
+// until ctx is cancelled.
+func (app *application) handleReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				err := app.reloadConfig()
+				if err != nil {
+					app.logger.Error("configuration reload rejected", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// reloadConfig() reads the configuration again, from the same file, environment and
+// command line as at startup, and switches to the new log level, CORS, rate limiting
+// and SMTP settings. Anything else needs a restart to change. If the new configuration
+// isn't valid, it is rejected as a whole and the current one is kept.
+//
+// The log level is only changed if the configured level has changed, so that a reload
+// for some other reason doesn't undo a change made through PUT /v1/admin/log-level.
+func (app *application) reloadConfig() error {
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	// As at startup, problems reading the configuration and invalid values are
+	// reported together.
+	next, _, err := readConfig(fs, os.Args[1:])
+
+	v := validator.New()
+	next.validate(v)
+
+	if !v.Valid() {
+		err = errors.Join(err, configErrors(v))
+	}
+	if err != nil {
+		return err
+	}
+
+	current := app.config()
+
+	updated := *current
+	updated.logLevel = next.logLevel
+	updated.cors = next.cors
+	updated.limiter = next.limiter
+	updated.smtp = next.smtp
+
+	// Work out whether anything else was changed, by comparing the new configuration
+	// with the reloadable parts put back as they were.
+	ignored := next
+	ignored.logLevel = current.logLevel
+	ignored.cors = current.cors
+	ignored.limiter = current.limiter
+	ignored.smtp = current.smtp
+
+	if !reflect.DeepEqual(ignored, *current) {
+		app.logger.Warn("configuration reload ignored settings which need a restart")
+	}
+
+	changes := reloadableChanges(current, &updated)
+	if len(changes) == 0 {
+		app.logger.Info("configuration reloaded without changes")
+		return nil
+	}
+
+	if updated.logLevel != current.logLevel {
+		err = app.logLevel.UnmarshalText([]byte(updated.logLevel))
+		if err != nil {
+			return err
+		}
+	}
+
+	if updated.smtp != current.smtp {
+		app.mailer.Update(updated.smtp.host, updated.smtp.port, updated.smtp.username, updated.smtp.password, updated.smtp.sender)
+	}
+
+	app.cfg.Store(&updated)
+	app.checkRouteLimits(&updated)
+
+	app.logger.Info("configuration reloaded", slog.Any("changes", slog.GroupValue(changes...)))
+
+	return nil
+}
+
+// reloadableChanges() describes how each reloadable setting which differs between old
+// and new has changed. Credentials are only reported as changed.
+func reloadableChanges(old, new *config) []slog.Attr {
+	var changes []slog.Attr
+
+	diff := func(name string, o, n any) {
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, slog.String(name, fmt.Sprintf("%v -> %v", o, n)))
+		}
+	}
+	secret := func(name string, o, n string) {
+		if o != n {
+			changes = append(changes, slog.String(name, "changed"))
+		}
+	}
+
+	diff("log-level", old.logLevel, new.logLevel)
+
+	diff("cors-trusted-origins", old.cors.trustedOrigins, new.cors.trustedOrigins)
+	diff("cors-max-age", old.cors.maxAge, new.cors.maxAge)
+
+	diff("limiter-enabled", old.limiter.enabled, new.limiter.enabled)
+	diff("limiter-rps", old.limiter.anonymous.Rate, new.limiter.anonymous.Rate)
+	diff("limiter-burst", old.limiter.anonymous.Burst, new.limiter.anonymous.Burst)
+	diff("limiter-user-rps", old.limiter.user.Rate, new.limiter.user.Rate)
+	diff("limiter-user-burst", old.limiter.user.Burst, new.limiter.user.Burst)
+	diff("limiter-ip-rps", old.limiter.ip.Rate, new.limiter.ip.Rate)
+	diff("limiter-ip-burst", old.limiter.ip.Burst, new.limiter.ip.Burst)
+	diff("limiter-route", old.limiter.routes, new.limiter.routes)
+
+	diff("smtp-host", old.smtp.host, new.smtp.host)
+	diff("smtp-port", old.smtp.port, new.smtp.port)
+	secret("smtp-username", old.smtp.username, new.smtp.username)
+	secret("smtp-password", old.smtp.password, new.smtp.password)
+	diff("smtp-sender", old.smtp.sender, new.smtp.sender)
+
+	return changes
+}
+
+// configErrors() turns the problems found by validate() into a single error.
+func configErrors(v *validator.Validator) error {
+	problems := make([]string, 0, len(v.Errors))
+	for name, message := range v.Errors {
+		problems = append(problems, name+": "+message)
+	}
+	sort.Strings(problems)
+
+	return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+}
@@ -16,23 +16,123 @@ func (app *application) routes() http.Handler {
 	r := httprouter.New()
 
 	// we use our error helper mthod to override the built-in `NotFound` & `methodNotAllowed` error responses.
-	r.NotFound = http.HandlerFunc(app.notFoundResponse)
-	r.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	r.NotFound = app.rateLimit("", app.notFoundResponse)
+	r.MethodNotAllowed = app.rateLimit("", app.methodNotAllowedResponse)
 
-	r.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	// handle() registers a route behind the rate limiter. Routes are named by their
+	// method and pattern, such as "GET /v1/movie/:id", which is how the limiter-route
+	// flag refers to them.
+	routes := make(map[string]bool)
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		route := method + " " + pattern
+		routes[route] = true
+		r.HandlerFunc(method, pattern, app.setRoute(method, pattern, app.rateLimit(route, handler)))
+	}
 
-	// movie route
-	r.HandlerFunc(http.MethodPost, "/v1/movie", app.requirePermission("movie:write", app.createMovieHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/movie", app.requirePermission("movie:read", app.listMovieHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/movie/:id", app.requirePermission("movie:read", app.showMovieHandler))
-	r.HandlerFunc(http.MethodPatch, "/v1/movie/:id", app.requirePermission("movie:write", app.updateMovieHandler))
-	r.HandlerFunc(http.MethodDelete, "/v1/movie/:id", app.requirePermission("movie:write", app.deleteMovieHandler))
+	// handleUnlimited() registers a route which isn't rate limited, and which
+	// limitClientIP() lets through as well. It's for health probes and metrics scrapes,
+	// which often all come from one address, and which would make an orchestrator
+	// restart a healthy instance if they were refused. The pattern must be a plain path
+	// without parameters.
+	unlimited := make(map[string]bool)
+	handleUnlimited := func(method, pattern string, handler http.HandlerFunc) {
+		unlimited[method+" "+pattern] = true
+		r.HandlerFunc(method, pattern, app.setRoute(method, pattern, handler))
+	}
+
+	handleUnlimited(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	handle(http.MethodGet, "/v1/version", app.versionHandler)
+
+	// Liveness and readiness probes for orchestrators and load balancers.
+	handleUnlimited(http.MethodGet, "/v1/health/live", app.livenessHandler)
+	handleUnlimited(http.MethodGet, "/v1/health/ready", app.readinessHandler)
+
+	// movie route. Creating a movie needs either movie:write or movie:write:own, and
+	// the update and delete handlers then check movieWritePolicy against the movie.
+	handle(http.MethodPost, "/v1/movie", app.requireAnyPermission(movieWritePolicy.Codes(), app.createMovieHandler))
+	handle(http.MethodGet, "/v1/movie", app.requirePermission("movie:read", app.listMovieHandler))
+	handle(http.MethodGet, "/v1/movie/:id", app.requirePermission("movie:read", app.showMovieHandler))
+	handle(http.MethodPatch, "/v1/movie/:id", app.requireAnyPermission(movieWritePolicy.Codes(), app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movie/:id", app.requireAnyPermission(movieWritePolicy.Codes(), app.deleteMovieHandler))
 
 	// User route handler
-	r.HandlerFunc(http.MethodPost, "/v1/users", app.createUserHandler)
-	r.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	r.HandlerFunc(http.MethodPost, "/v1/users/authentication", app.authenticationHandler)
+	handle(http.MethodPost, "/v1/users", app.createUserHandler)
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPost, "/v1/users/authentication", app.authenticationHandler)
+	handle(http.MethodPost, "/v1/users/authentication/totp", app.verifyTOTPHandler)
+	handle(http.MethodPut, "/v1/invitations/accepted", app.acceptInvitationHandler)
+
+	// Two-factor authentication routes
+	handle(http.MethodPost, "/v1/users/me/totp", app.requireUnscopedUser(app.enrollTOTPHandler))
+	handle(http.MethodPut, "/v1/users/me/totp/confirmed", app.requireUnscopedUser(app.confirmTOTPHandler))
+	handle(http.MethodDelete, "/v1/users/me/totp", app.requireUnscopedUser(app.disableTOTPHandler))
+
+	// Current user routes
+	handle(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	handle(http.MethodPatch, "/v1/users/me", app.requireUnscopedUser(app.updateCurrentUserHandler))
+	handle(http.MethodPut, "/v1/users/me/password", app.requireUnscopedUser(app.updatePasswordHandler))
+	handle(http.MethodPost, "/v1/users/me/email", app.requireUnscopedUser(app.requestEmailChangeHandler))
+	handle(http.MethodPut, "/v1/users/email/confirmed", app.confirmEmailChangeHandler)
+	handle(http.MethodPut, "/v1/users/email/cancelled", app.cancelEmailChangeHandler)
+
+	// Personal data export and account deletion routes
+	handle(http.MethodGet, "/v1/users/me/export", app.requireUnscopedUser(app.exportCurrentUserHandler))
+	handle(http.MethodDelete, "/v1/users/me", app.requireUnscopedUser(app.requestAccountDeletionHandler))
+	handle(http.MethodDelete, "/v1/users/me/deletion", app.requireUnscopedUser(app.cancelAccountDeletionHandler))
+
+	// API key routes
+	handle(http.MethodPost, "/v1/users/me/api-keys", app.requireUnscopedUser(app.createAPIKeyHandler))
+	handle(http.MethodGet, "/v1/users/me/api-keys", app.requireUnscopedUser(app.listAPIKeysHandler))
+	handle(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireUnscopedUser(app.deleteAPIKeyHandler))
+
+	// OAuth routes. The token, introspection and revocation endpoints authenticate the
+	// client rather than a user, so they don't use any of the user middleware.
+	handle(http.MethodPost, "/v1/oauth/clients", app.requireUnscopedUser(app.createOAuthClientHandler))
+	handle(http.MethodGet, "/v1/oauth/clients", app.requireUnscopedUser(app.listOAuthClientsHandler))
+	handle(http.MethodDelete, "/v1/oauth/clients/:id", app.requireUnscopedUser(app.deleteOAuthClientHandler))
+	handle(http.MethodGet, "/v1/oauth/authorize", app.requireUnscopedUser(app.showAuthorizationHandler))
+	handle(http.MethodPost, "/v1/oauth/authorize", app.requireUnscopedUser(app.approveAuthorizationHandler))
+	handle(http.MethodPost, "/v1/oauth/token", app.tokenHandler)
+	handle(http.MethodPost, "/v1/oauth/introspect", app.introspectHandler)
+	handle(http.MethodPost, "/v1/oauth/revoke", app.revokeHandler)
+	handle(http.MethodGet, "/v1/users/me/oauth/consents", app.requireUnscopedUser(app.listOAuthConsentsHandler))
+	handle(http.MethodDelete, "/v1/users/me/oauth/consents/:id", app.requireUnscopedUser(app.deleteOAuthConsentHandler))
+
+	// Admin routes
+	handle(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	handle(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	handle(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+	handle(http.MethodGet, "/v1/admin/users/:id/audit", app.requirePermission("users:admin", app.listUserAuditHandler))
+	handle(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivationHandler))
+	handle(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantPermissionsHandler))
+	handle(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokePermissionHandler))
+	handle(http.MethodPut, "/v1/admin/users/:id/unlock", app.requirePermission("users:admin", app.unlockUserHandler))
+	handle(http.MethodGet, "/v1/admin/log-level", app.requirePermission("users:admin", app.showLogLevelHandler))
+	handle(http.MethodPut, "/v1/admin/log-level", app.requirePermission("users:admin", app.updateLogLevelHandler))
+	handle(http.MethodPost, "/v1/admin/invitations", app.requirePermission("users:admin", app.createInvitationHandler))
+	handle(http.MethodPost, "/v1/admin/users/:id/impersonation", app.requireUnscopedUser(app.requirePermission("admin:impersonate", app.impersonateUserHandler)))
+
+	// Prometheus metrics are only served when scrape credentials have been set.
+	if app.config().metrics.username != "" {
+		handleUnlimited(http.MethodGet, "/metrics", app.requireMetricsAuth(app.metrics.registry.Handler().ServeHTTP))
+	}
+
+	app.routeNames = routes
+	app.unlimitedRoutes = unlimited
+	app.checkRouteLimits(app.config())
+
+	// Use the authenticate() middleware on all requests. Each client IP address is
+	// limited before it, so that authentication can't be used to load the database, and
+	// the per-user and per-route limits are applied after it, inside the router.
+	return app.requestContext(app.traceRequest(app.accessLog(app.recordMetrics(app.recoverPanic(app.enableCORS(app.limitClientIP(app.authenticate(r))))))))
+}
 
-	// Use the authenticate() middleware on all requests.
-	return app.recoverPanic(app.enableCORS(app.authenticate(r)))
+// checkRouteLimits() warns about rate limit overrides for routes which don't exist, which
+// are almost certainly typos.
+func (app *application) checkRouteLimits(cfg *config) {
+	for route := range cfg.limiter.routes {
+		if !app.routeNames[route] {
+			app.logger.Warn("limiter-route does not match any route", "route", route)
+		}
+	}
 }
@@ -4,29 +4,50 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/vcs"
 )
 
+// shutdownTimeout is how long in-flight requests have to finish once the server stops
+// accepting new ones. Together with shutdown-drain-delay it must fit within the grace
+// period that the orchestrator allows between SIGTERM and SIGKILL.
+const shutdownTimeout = 5 * time.Second
+
 func (app *application) serve() error {
 	// Declare a HTTP server with some sensible timeout settings, which listens on the
 	// port provided in the config struct and uses the servemux we created above as the
 	// handler.
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Addr:         fmt.Sprintf(":%d", app.config().port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		// Send the errors that the server logs itself, such as TLS handshake
+		// failures, through our structured logger too.
+		ErrorLog: slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
+	// Start the periodic background jobs. They run until shutdown begins, and are
+	// tracked by app.wg, so the wait below also lets a job which is part way through
+	// finish.
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	app.startJobs(jobs)
+
+	// Reload the configuration whenever the process receives SIGHUP.
+	app.handleReloads(jobs)
+
 	// start the Background goroutine
 	go func() {
 
@@ -39,13 +60,19 @@ func (app *application) serve() error {
 		// received.
 		s := <-quit
 
-		app.logger.Printf("Caught signal:", s.String())
-		app.logger.Printf("Shutting down server:", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// Fail the readiness check from now on, and keep serving for a while so that
+		// load balancers can stop sending new requests before connections are closed.
+		app.shuttingDown.Store(true)
+
+		if delay := app.config().shutdown.drainDelay; delay > 0 {
+			app.logger.Info("draining traffic", "delay", delay.String())
+			time.Sleep(delay)
+		}
 
 		// Create a context with a 5-second timeout.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
 
 		// Call Shutdown() on the server like before, but now we only send on the
@@ -55,11 +82,12 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// Tell the background jobs to stop.
+		stopJobs()
+
 		// Log a message to say that we're waiting for any background goroutines to
 		// complete their tasks.
-		app.logger.Printf("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
 		// Call Wait() to block until our WaitGroup counter is zero --- essentially
 		// blocking until the background goroutines have finished. Then we return nil on
@@ -70,10 +98,8 @@ func (app *application) serve() error {
 
 	}()
 
-	app.logger.Printf("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
+	build := vcs.Get()
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config().env, "version", build.Version, "commit", build.Commit)
 
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
@@ -94,9 +120,7 @@ func (app *application) serve() error {
 
 	// At this point we know that the graceful shutdown completed successfully and we
 	// log a "stopped server" message.
-	app.logger.Printf("stopped server", map[string]string{
-		"addr": srv.Addr,
-	})
+	app.logger.Info("stopped server", "addr", srv.Addr)
 
 	return nil
 }
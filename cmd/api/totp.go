@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/data"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/totp"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// The issuer shown next to the account name in authenticator apps.
+const totpIssuer = "Greenlight"
+
+// enrollTOTPHandler starts two-factor enrollment by generating a new secret. Nothing
+// changes for the user until the secret is confirmed with a valid code.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	settings, err := app.modelsFor(r).TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if settings != nil && settings.Enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).TOTP.Enroll(user.ID, secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The provisioning URI is what the client should render as a QR code. The raw
+	// secret is included for users who need to type it in by hand.
+	env := envelope{
+		"totp": map[string]string{
+			"secret":           totp.EncodeSecret(secret),
+			"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler enables two-factor authentication once the user has shown that
+// their authenticator app is generating valid codes, and returns their recovery codes.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	settings, err := app.modelsFor(r).TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if settings.Enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := totp.Verify(settings.Secret, input.Code, time.Now(), settings.LastUsedStep)
+	if !ok {
+		v.AddError("code", "invalid one-time code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := app.modelsFor(r).TOTP.Enable(user.ID, step)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Any existing sessions were created with a password alone, so we revoke them and
+	// make the user sign in again with their second factor.
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler turns two-factor authentication off. It requires a current code
+// (or a recovery code) so that a stolen session token alone can't remove the second
+// factor.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	ok, err := app.verifySecondFactor(r, user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is not enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid one-time code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifyTOTPHandler completes a two-factor login. It exchanges the pending token
+// returned by authenticationHandler, together with a one-time code or recovery code,
+// for a normal authentication token.
+func (app *application) verifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if input.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).User.GetForToken(data.ScopeTOTPPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	wait, err := app.checkLoginAllowed(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r, user.ID, input.Code, input.RecoveryCode)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A pending token is good for a single attempt. Without this a client holding
+	// the password could keep guessing codes for as long as the token is valid, and
+	// a six digit code doesn't survive that for long.
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeTOTPPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.recordLoginSuccess(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifySecondFactor() checks a one-time code, or if no code was given a recovery code,
+// for a user with two-factor authentication enabled. It returns ErrRecordNotFound if the
+// user hasn't enabled two-factor authentication.
+func (app *application) verifySecondFactor(r *http.Request, userID int64, code, recoveryCode string) (bool, error) {
+
+	settings, err := app.modelsFor(r).TOTP.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if !settings.Enabled {
+		return false, data.ErrRecordNotFound
+	}
+
+	if code == "" {
+		err = app.modelsFor(r).TOTP.UseRecoveryCode(userID, recoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	step, ok := totp.Verify(settings.Secret, code, time.Now(), settings.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+
+	// Only accept each code once, even though it stays valid for the rest of its time
+	// step. Verify() has already rejected a replayed code, but UseStep() also catches
+	// two requests racing to use the same one.
+	return app.modelsFor(r).TOTP.UseStep(userID, step)
+}
@@ -35,12 +35,31 @@ func (app *application) authenticationHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Refuse the attempt outright if this client or account has failed to log in too
+	// many times recently, before we spend any time checking the password.
+	wait, err := app.checkLoginAllowed(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
 	// if Validated, now we have to look up on the database based on provided Email
 	// and check the password provided is matched!
-	user, err := app.models.User.GetByEmail(input.Email)
+	user, err := app.modelsFor(r).User.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// Guessing email addresses counts against the client IP too.
+			err = app.recordLoginFailure(r, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -55,13 +74,64 @@ func (app *application) authenticationHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	if !match {
+		err = app.recordLoginFailure(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	// Now that we have the plaintext password and know that it's right, take the
+	// chance to upgrade a hash made with an outdated algorithm or parameters. This is
+	// best effort: the login shouldn't fail because of it, and if the user record was
+	// changed concurrently the old hash still works and can be upgraded next time.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.modelsFor(r).User.UpdateUser(user)
+		}
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.logger.ErrorContext(r.Context(), "rehashing password", "user_id", user.ID, "error", err)
+		}
+	}
+
+	// If the user has two-factor authentication enabled, the password on its own isn't
+	// enough. Instead of an authentication token we issue a short-lived pending token,
+	// which the client exchanges for an authentication token at
+	// POST /v1/users/authentication/totp along with a one-time code.
+	settings, err := app.modelsFor(r).TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if settings != nil && settings.Enabled {
+		token, err := app.modelsFor(r).Tokens.New(user.ID, 5*time.Minute, data.ScopeTOTPPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"totp_required": true, "token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// For users with two-factor authentication the failure count is only cleared once
+	// the second factor has been checked too, in verifyTOTPHandler.
+	err = app.recordLoginSuccess(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.modelsFor(r).Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -97,7 +167,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// Retrieve the details of the user associated with the token using the
 	// GetForToken() method (which we will create in a minute). If no matching record
 	// is found, then we let the client know that the token they provided is not valid.
-	user, err := app.models.User.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.modelsFor(r).User.GetForToken(data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -114,7 +184,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
-	err = app.models.User.UpdateUser(user)
+	err = app.modelsFor(r).User.UpdateUser(user)
 	if err != nil {
 		switch {
 		// ErrEditConflict is used for `Optimistic Locking`
@@ -129,7 +199,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// If everything went successfully, then we delete all activation tokens for the
 	// user.
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -144,6 +214,12 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request) {
 
+	// In closed registration mode, users join by accepting an invitation instead.
+	if app.config().signup.disabled {
+		app.signupDisabledResponse(w, r)
+		return
+	}
+
 	// first,
 	//we need to convet payload json to go struct
 	// to do so, we need to create a temporary struct fist
@@ -181,14 +257,30 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 
 	v := validator.New()
 
-	// Validate the user struct and return the error messages to the client if any of
-	// the checks fail.
-	if data.ValidateUser(v, user); !v.Valid() {
+	// Validate the user struct and screen the password against the password policy,
+	// and return the error messages to the client if any of the checks fail.
+	data.ValidateUser(v, user)
+
+	err = app.passwordPolicy.Check(v, "password", input.Password, user.Email, user.Name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.User.Insert(user)
+	// Give the new user the configured default role, which determines what they can
+	// do once their account is activated. It is added in the same transaction as the
+	// user, so that a failure doesn't leave an account without a role.
+	var roles []string
+	if role := app.config().signup.defaultRole; role != "" {
+		roles = append(roles, role)
+	}
+
+	err = app.modelsFor(r).User.Insert(user, roles...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -201,15 +293,9 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movie:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	// After the user record has been created in the database, generate a new activation
 	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.modelsFor(r).Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -227,12 +313,12 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 			"userID":          user.ID,
 		}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.Send(r.Context(), user.Email, "user_welcome.tmpl", data)
 		if err != nil {
 			// Importantly, if there is an error sending the email then we use the
 			// app.logger.PrintError() helper to manage it, instead of the
 			// app.serverErrorResponse() helper like before.
-			app.logger.Println(err)
+			app.logger.Error(err.Error())
 		}
 	})
 
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+)
+
+// Define a string constant containing the HTML for the webpage. The form takes an
+// authentication token and a movie, and the JavaScript sends a PATCH /v1/movie/:id
+// request with an Authorization header. Because of the PATCH method and the
+// Authorization header, this isn't a "simple" cross-origin request, so the browser
+// sends a preflight OPTIONS request first and only sends the PATCH if the API allows
+// it. Both requests can be seen in the network tab of the browser's developer tools.
+//
+// Start the API with this page's origin trusted, for example:
+//
+//	go run ./cmd/api -cors-trusted-origins="http://localhost:9000"
+const html = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+</head>
+<body>
+<h1>Preflight CORS</h1>
+<form id="form">
+<p><label>Authentication token <input id="token" size="30"></label></p>
+<p><label>Movie ID <input id="id" size="5" value="1"></label></p>
+<p><label>New title <input id="title" size="30"></label></p>
+<p><button type="submit">Update movie</button></p>
+</form>
+<div id="output"></div>
+<script>
+document.getElementById("form").addEventListener("submit", function(event) {
+event.preventDefault();
+const id = document.getElementById("id").value;
+fetch("http://localhost:4000/v1/movie/" + encodeURIComponent(id), {
+method: "PATCH",
+headers: {
+"Authorization": "Bearer " + document.getElementById("token").value,
+"Content-Type": "application/json"
+},
+body: JSON.stringify({
+title: document.getElementById("title").value
+})
+}).then(
+function (response) {
+response.text().then(function (text) {
+document.getElementById("output").innerHTML = text;
+});
+},
+function(err) {
+document.getElementById("output").innerHTML = err;
+}
+);
+});
+</script>
+</body>
+</html>`
+
+func main() {
+	addr := flag.String("addr", ":9000", "Server address")
+	flag.Parse()
+	log.Printf("starting server on %s", *addr)
+	err := http.ListenAndServe(*addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(html))
+	}))
+	log.Fatal(err)
+}
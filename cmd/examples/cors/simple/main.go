@@ -9,6 +9,10 @@ import (
 // Define a string constant containing the HTML for the webpage. This consists of a <h1>
 // header tag, and some JavaScript which fetches the JSON from our GET /v1/healthcheck
 // endpoint and writes it to inside the <div id="output"></div> element.
+//
+// Start the API with this page's origin trusted, for example:
+//
+//	go run ./cmd/api -cors-trusted-origins="http://localhost:9000"
 const html = `
 <!DOCTYPE html>
 <html lang="en">
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// This is a fake OAuth client for trying out the API's authorization server locally.
+// It plays every part in the authorization code flow: it builds the authorization
+// request, approves it on the user's behalf using their authentication token (standing
+// in for the consent screen), exchanges the code for an access token, uses the token,
+// and finally introspects and revokes it.
+//
+// Register a client first with POST /v1/oauth/clients, using a redirect URI such as
+// http://localhost:9000/callback. Nothing needs to listen on the redirect URI, because
+// the code is read straight out of the URL that the user would be sent to.
+func main() {
+	api := flag.String("api", "http://localhost:4000", "API base URL")
+	userToken := flag.String("token", "", "Authentication token of the user approving the request")
+	clientID := flag.String("client-id", "", "OAuth client ID")
+	clientSecret := flag.String("client-secret", "", "OAuth client secret (empty for a public client)")
+	redirectURI := flag.String("redirect-uri", "http://localhost:9000/callback", "Registered redirect URI")
+	scope := flag.String("scope", "movie:read", "Space separated scopes to ask for")
+	flag.Parse()
+
+	if *userToken == "" || *clientID == "" {
+		log.Fatal("-token and -client-id are required")
+	}
+
+	c := &client{api: *api, id: *clientID, secret: *clientSecret}
+
+	// Create the PKCE code verifier and its S256 challenge, and a state value to tie
+	// the response to this request.
+	verifier := randomString(32)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	state := randomString(16)
+
+	authorization := map[string]string{
+		"response_type":         "code",
+		"client_id":             *clientID,
+		"redirect_uri":          *redirectURI,
+		"scope":                 *scope,
+		"state":                 state,
+		"code_challenge":        challenge,
+		"code_challenge_method": "S256",
+	}
+
+	// 1. The consent screen asks the API to describe the request.
+	qs := url.Values{}
+	for key, value := range authorization {
+		qs.Set(key, value)
+	}
+	var described map[string]any
+	c.do("GET", "/v1/oauth/authorize?"+qs.Encode(), "Bearer "+*userToken, nil, "", &described)
+	log.Printf("authorization request: %v", described)
+
+	// 2. The user approves it.
+	body := map[string]any{"approved": true}
+	for key, value := range authorization {
+		body[key] = value
+	}
+	js, err := json.Marshal(body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var approved struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	c.do("POST", "/v1/oauth/authorize", "Bearer "+*userToken, strings.NewReader(string(js)), "application/json", &approved)
+	log.Printf("user would be redirected to: %s", approved.RedirectTo)
+
+	// 3. The client receives the code at its redirect URI.
+	redirect, err := url.Parse(approved.RedirectTo)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if redirect.Query().Get("state") != state {
+		log.Fatal("state does not match")
+	}
+	code := redirect.Query().Get("code")
+
+	// 4. The client exchanges the code for an access token.
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
+	}
+	c.form("/v1/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {*redirectURI},
+		"code_verifier": {verifier},
+	}, &token)
+	log.Printf("access token expires in %ds with scope %q", token.ExpiresIn, token.Scope)
+
+	// 5. The client uses the access token.
+	var movies map[string]any
+	c.do("GET", "/v1/movie", "Bearer "+token.AccessToken, nil, "", &movies)
+	log.Printf("GET /v1/movie: %v", movies)
+
+	// 6. Introspection needs a confidential client.
+	if *clientSecret != "" {
+		var info map[string]any
+		c.form("/v1/oauth/introspect", url.Values{"token": {token.AccessToken}}, &info)
+		log.Printf("introspection: %v", info)
+	}
+
+	// 7. The client revokes the token when it's done with it.
+	c.form("/v1/oauth/revoke", url.Values{"token": {token.AccessToken}}, nil)
+	log.Print("token revoked")
+}
+
+type client struct {
+	api    string
+	id     string
+	secret string
+}
+
+// form() posts a form to one of the client-authenticated OAuth endpoints.
+func (c *client) form(path string, values url.Values, dst any) {
+	auth := ""
+	if c.secret != "" {
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(c.id)+":"+url.QueryEscape(c.secret)))
+	} else {
+		values.Set("client_id", c.id)
+	}
+
+	c.do("POST", path, auth, strings.NewReader(values.Encode()), "application/x-www-form-urlencoded", dst)
+}
+
+func (c *client) do(method, path, auth string, body io.Reader, contentType string, dst any) {
+	req, err := http.NewRequest(method, c.api+path, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if res.StatusCode >= 300 {
+		log.Fatalf("%s %s: %s: %s", method, path, res.Status, raw)
+	}
+
+	if dst != nil {
+		err = json.Unmarshal(raw, dst)
+		if err != nil {
+			log.Fatal(fmt.Errorf("%s %s: %w", method, path, err))
+		}
+	}
+}
+
+// randomString() returns n random bytes encoded as unpadded base64url, which is safe to
+// use as a PKCE code verifier or state value.
+func randomString(n int) string {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
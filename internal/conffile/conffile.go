@@ -0,0 +1,280 @@
+// Package conffile reads configuration files written in a subset of TOML
+// (https://toml.io): tables, dotted keys, strings, integers, floats, booleans and arrays
+// of those. Inline tables, dates and multi-line strings aren't supported.
+//
+// Keys are flattened into names joined with "-", and underscores become "-" as well, so
+//
+//	[smtp]
+//	max_retries = 3
+//
+// is read as the key "smtp-max-retries" with the value "3". This lets a file set the same
+// settings as the application's command-line flags. Array values are returned one
+// element at a time, and everything else as a single element.
+package conffile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadFile reads and parses the file at path.
+func ReadFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Parse parses a configuration file, returning the values for each key.
+func Parse(r io.Reader) (map[string][]string, error) {
+	values := make(map[string][]string)
+	prefix := ""
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		start := lineNo
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(stripComment(line), "]")
+			if !ok {
+				return nil, fmt.Errorf("line %d: table header must end with ]", lineNo)
+			}
+			key, err := parseKey(name[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			prefix = key + "-"
+			continue
+		}
+
+		name, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+
+		key, err := parseKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		key = prefix + key
+
+		// An array may carry on over several lines, until its brackets balance.
+		raw = stripComment(raw)
+		for strings.HasPrefix(raw, "[") && !arrayClosed(raw) && scanner.Scan() {
+			lineNo++
+			raw += " " + stripComment(scanner.Text())
+		}
+
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set more than once", start, key)
+		}
+
+		parsed, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", start, key, err)
+		}
+		values[key] = parsed
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// parseKey() flattens a bare or dotted key, such as "smtp.max_retries", into
+// "smtp-max-retries".
+func parseKey(s string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return "", fmt.Errorf("empty key in %q", s)
+		}
+		for _, c := range part {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", fmt.Errorf("invalid key %q", s)
+			}
+		}
+		parts[i] = strings.ReplaceAll(strings.ToLower(part), "_", "-")
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// parseValue() parses a value, which is either an array or a single scalar.
+func parseValue(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := parseScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return []string{v}, nil
+	}
+
+	var elems []string
+	s = strings.TrimSpace(s[1:])
+
+	for {
+		if strings.HasPrefix(s, "]") {
+			if strings.TrimSpace(s[1:]) != "" {
+				return nil, fmt.Errorf("unexpected %q after array", s[1:])
+			}
+			// A value which is present but empty still needs an entry, so that an
+			// empty array can override a setting.
+			if elems == nil {
+				elems = []string{}
+			}
+			return elems, nil
+		}
+
+		v, rest, err := parseScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, v)
+
+		rest = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(rest, ","):
+			s = strings.TrimSpace(rest[1:])
+		case strings.HasPrefix(rest, "]"):
+			s = rest
+		default:
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// parseScalar() parses the string, number or boolean at the start of s, and returns it
+// along with the rest of s.
+func parseScalar(s string) (string, string, error) {
+	switch {
+	case s == "":
+		return "", "", fmt.Errorf("missing value")
+
+	case s[0] == '"':
+		end := closingQuote(s)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid string %s", s[:end+1])
+		}
+		return v, s[end+1:], nil
+
+	case s[0] == '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	end := strings.IndexAny(s, ",] \t")
+	if end < 0 {
+		end = len(s)
+	}
+	v := s[:end]
+
+	if v != "true" && v != "false" {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+			return "", "", fmt.Errorf("invalid value %q (strings must be quoted)", v)
+		}
+		v = strings.ReplaceAll(v, "_", "")
+	}
+
+	return v, s[end:], nil
+}
+
+// closingQuote() returns the index of the double quote which ends the basic string at
+// the start of s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment() removes a trailing comment, taking care not to treat a # inside a
+// string as one.
+func stripComment(s string) string {
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimSpace(s[:i])
+		}
+	}
+
+	return strings.TrimSpace(s)
+}
+
+// arrayClosed() reports whether the brackets of an array value balance, ignoring any
+// inside strings.
+func arrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
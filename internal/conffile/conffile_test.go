@@ -0,0 +1,150 @@
+package conffile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string][]string
+	}{
+		{
+			name:  "scalars",
+			input: "port = 4000\nrate = 0.5\nenabled = true\nenv = \"production\"\nlevel = 'debug'\nbig = 1_000_000",
+			want: map[string][]string{
+				"port":    {"4000"},
+				"rate":    {"0.5"},
+				"enabled": {"true"},
+				"env":     {"production"},
+				"level":   {"debug"},
+				"big":     {"1000000"},
+			},
+		},
+		{
+			name:  "tables",
+			input: "port = 4000\n\n[smtp]\nhost = \"localhost\"\nmax_retries = 3\n\n[db] # the database\nmax-open-conns = 25",
+			want: map[string][]string{
+				"port":              {"4000"},
+				"smtp-host":         {"localhost"},
+				"smtp-max-retries":  {"3"},
+				"db-max-open-conns": {"25"},
+			},
+		},
+		{
+			name:  "dotted keys",
+			input: "smtp.host = \"localhost\"\nDB . Max_Open_Conns = 25\n[limiter.route]\nenabled = false",
+			want: map[string][]string{
+				"smtp-host":             {"localhost"},
+				"db-max-open-conns":     {"25"},
+				"limiter-route-enabled": {"false"},
+			},
+		},
+		{
+			name:  "arrays",
+			input: "origins = [\"https://a.example.com\", 'https://b.example.com']\nempty = []\nnumbers = [1, 2,3]",
+			want: map[string][]string{
+				"origins": {"https://a.example.com", "https://b.example.com"},
+				"empty":   {},
+				"numbers": {"1", "2", "3"},
+			},
+		},
+		{
+			name: "multi-line arrays",
+			input: `route = [
+  "POST /v1/users/authentication=0.5:5", # logins
+  # a comment on its own line
+  "GET /v1/movies=10:20",
+]
+after = 1`,
+			want: map[string][]string{
+				"route": {"POST /v1/users/authentication=0.5:5", "GET /v1/movies=10:20"},
+				"after": {"1"},
+			},
+		},
+		{
+			name:  "comments",
+			input: "# a comment\n  # an indented comment\nport = 4000 # the port\nenabled = true#no space",
+			want: map[string][]string{
+				"port":    {"4000"},
+				"enabled": {"true"},
+			},
+		},
+		{
+			name:  "comments inside strings",
+			input: "password = \"pass#word\" # the real comment\nsender = 'Greenlight # no-reply'\nlist = [\"a#b\", 'c]#d'] # done",
+			want: map[string][]string{
+				"password": {"pass#word"},
+				"sender":   {"Greenlight # no-reply"},
+				"list":     {"a#b", "c]#d"},
+			},
+		},
+		{
+			name:  "escapes",
+			input: `dsn = "host=\"db\" user=greenlight\tpassword=pa\\ss"`,
+			want: map[string][]string{
+				"dsn": {"host=\"db\" user=greenlight\tpassword=pa\\ss"},
+			},
+		},
+		{
+			name:  "equals sign in a value",
+			input: `dsn = "postgres://greenlight@localhost/greenlight?sslmode=disable"`,
+			want: map[string][]string{
+				"dsn": {"postgres://greenlight@localhost/greenlight?sslmode=disable"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"duplicate key", "port = 1\nport = 2", "line 2: port is set more than once"},
+		{"duplicate key in a table", "[smtp]\nhost = \"a\"\n[db]\ndsn = \"b\"\n[smtp]\nhost = \"c\"", "line 6: smtp-host is set more than once"},
+		{"duplicate of a dotted key", "smtp.host = \"a\"\n[smtp]\nhost = \"b\"", "line 3: smtp-host is set more than once"},
+		{"duplicate after flattening", "max_retries = 1\nmax-retries = 2", "line 2: max-retries is set more than once"},
+		{"duplicate multi-line array", "a = [\n1,\n]\na = [\n2,\n]", "line 4: a is set more than once"},
+		{"unclosed table header", "[smtp\nhost = \"a\"", "line 1: table header must end with ]"},
+		{"empty table name", "[]", "line 1: empty key"},
+		{"missing equals sign", "port 4000", "line 1: expected key = value"},
+		{"empty dotted key part", "smtp..host = 1", "line 1: empty key"},
+		{"invalid key", "smtp host = 1", "line 1: invalid key"},
+		{"missing value", "port =", "line 1: port: missing value"},
+		{"unquoted string", "env = production", `line 1: env: invalid value "production" (strings must be quoted)`},
+		{"unterminated string", `env = "production`, "line 1: env: unterminated string"},
+		{"unterminated literal string", `env = 'production`, "line 1: env: unterminated string"},
+		{"text after value", `env = "a" "b"`, `line 1: env: unexpected " \"b\"" after value`},
+		{"unclosed array", "route = [\n\"a\",\n\"b\"", "line 1: route: expected , or ] in array"},
+		{"missing comma", `route = ["a" "b"]`, "line 1: route: expected , or ] in array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q; want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
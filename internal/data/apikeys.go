@@ -0,0 +1,238 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// An APIKey is a long-lived credential which authenticates as its owner, but which is
+// limited to a subset of the owner's permission codes. Like a Token, only the SHA-256
+// hash of the key is stored in the database and the plaintext is shown exactly once,
+// when the key is created.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+}
+
+type APIKeyModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	// API keys are not short-lived like tokens, so we use 32 bytes of randomness
+	// instead of 16. Encoded as unpadded base-32 this gives us a 52 character key.
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+	return key, nil
+}
+
+// Check that the plaintext API key has been provided and is exactly 52 bytes long.
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == 52, "key", "must be 52 bytes long")
+}
+
+// ValidateAPIKey checks the user-supplied fields of a new key. The granted slice holds
+// the permission codes that the owner currently has, and the key may only be scoped to
+// codes from that slice.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(granted.Include(code), "permissions", "must only contain permissions that you have been granted")
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// The New() method is a shortcut which creates a new APIKey struct and then inserts the
+// data in the api_keys table.
+func (m APIKeyModel) New(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, hash, permissions, expiry)
+			VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Hash, pq.Array([]string(key.Permissions)), key.Expiry}
+
+	ctx, cancel := m.startQuery("APIKeyModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetAllForUser() returns every key owned by a user, including expired ones, so that
+// they can be listed and revoked. The plaintext is never available here.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, permissions, expiry
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := m.startQuery("APIKeyModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteForUser() revokes a single key. The user ID is part of the WHERE clause so that
+// users can only ever revoke their own keys.
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := m.startQuery("APIKeyModel.DeleteForUser", 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForKey() looks up the owner of an unexpired key along with the key itself, so that
+// the caller can restrict the request to the key's permissions.
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*User, *APIKey, error) {
+
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT
+			users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+			api_keys.id, api_keys.created_at, api_keys.name, api_keys.permissions, api_keys.expiry
+		FROM users
+			INNER JOIN api_keys
+			ON users.id = api_keys.user_id
+			WHERE api_keys.hash = $1
+			AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)`
+
+	var user User
+	var key APIKey
+
+	ctx, cancel := m.startQuery("APIKeyModel.GetForKey", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&key.ID,
+		&key.CreatedAt,
+		&key.Name,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+	key.Hash = keyHash[:]
+
+	return &user, &key, nil
+}
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Define constants for the actions recorded in the audit log.
+const (
+	AuditUserUnlocked           = "user.unlocked"
+	AuditUserActivated          = "user.activated"
+	AuditUserDeactivated        = "user.deactivated"
+	AuditUserDeleted            = "user.deleted"
+	AuditUserPermissionGranted  = "user.permission_granted"
+	AuditUserPermissionRevoked  = "user.permission_revoked"
+	AuditUserImpersonated       = "user.impersonated"
+	AuditUserInvited            = "user.invited"
+	AuditUserInvitationAccepted = "user.invitation_accepted"
+)
+
+// An AuditEntry records a change that an administrator made to a user account. The
+// actor is the administrator and the target is the user who was changed. Either can be
+// zero: an invitation has no target until it is accepted, and the administrator who
+// sent it may have been deleted by then.
+type AuditEntry struct {
+	ID           int64          `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ActorID      int64          `json:"actor_id"`
+	TargetUserID int64          `json:"target_user_id"`
+	Action       string         `json:"action"`
+	Details      map[string]any `json:"details,omitempty"`
+}
+
+type AuditModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// Insert a new entry in the audit log, on its own. A change to a user which is stored in
+// the database should be audited by the model method which makes it instead, so that
+// the change and its entry are written in the same transaction.
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := m.startQuery("AuditModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return insertAudit(ctx, m.db, entry)
+}
+
+// queryer is the part of *sql.DB that *sql.Tx also has, so that a query can be run
+// either on its own or as part of a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertAudit() writes an entry to the audit log with q.
+func insertAudit(ctx context.Context, q queryer, entry *AuditEntry) error {
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	// A nil map marshals to null, but the column holds an object.
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, target_user_id, action, details)
+			VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4)
+		RETURNING id, created_at`
+
+	args := []any{entry.ActorID, entry.TargetUserID, entry.Action, details}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// auditedTx() runs fn in a transaction, and writes entry to the audit log as part of the
+// same transaction. Either the change and its entry are both committed, or neither is.
+// A nil entry makes a change which isn't audited.
+func auditedTx(ctx context.Context, db *sql.DB, entry *AuditEntry, fn func(tx *sql.Tx) error) error {
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil {
+		err = insertAudit(ctx, tx, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAllForTarget() returns the audit entries for changes made to a user, newest first.
+func (m AuditModel) GetAllForTarget(userID int64) ([]*AuditEntry, error) {
+
+	query := `
+		SELECT id, created_at, COALESCE(actor_id, 0), target_user_id, action, details
+		FROM audit_log
+		WHERE target_user_id = $1
+		ORDER BY id DESC`
+
+	ctx, cancel := m.startQuery("AuditModel.GetAllForTarget", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.TargetUserID,
+			&entry.Action,
+			&details,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// anonymizeAudit() removes the personal details recorded about a user from their audit
+// entries with q, once the user has deleted their account. The entries themselves are
+// kept.
+func anonymizeAudit(ctx context.Context, q queryer, userID int64) error {
+	query := `
+		UPDATE audit_log
+		SET details = details - 'email'
+		WHERE target_user_id = $1`
+
+	_, err := q.ExecContext(ctx, query, userID)
+	return err
+}
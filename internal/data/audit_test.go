@@ -0,0 +1,74 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditedChangesAreAtomic(t *testing.T) {
+	models := newTestModels(t)
+	admin := insertTestUser(t, models)
+	user := insertTestUser(t, models)
+
+	// A successful change writes its audit entry too.
+	entry := &AuditEntry{ActorID: admin.ID, TargetUserID: user.ID, Action: AuditUserPermissionGranted}
+
+	err := models.Permissions.AddForUser(user.ID, []string{"movie:write"}, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := models.Audit.GetAllForTarget(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != AuditUserPermissionGranted {
+		t.Fatalf("got audit entries %+v; want one %s entry", entries, AuditUserPermissionGranted)
+	}
+
+	// If the audit entry can't be written, here because the actor doesn't exist, the
+	// change is rolled back.
+	bad := &AuditEntry{ActorID: -1, TargetUserID: user.ID, Action: AuditUserPermissionRevoked}
+
+	err = models.Permissions.RemoveForUser(user.ID, []string{"movie:write"}, bad)
+	if err == nil {
+		t.Fatal("RemoveForUser succeeded with an invalid audit entry")
+	}
+
+	permissions, err := models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Include("movie:write") {
+		t.Error("permission was revoked although its audit entry wasn't written")
+	}
+
+	bad = &AuditEntry{ActorID: -1, TargetUserID: user.ID, Action: AuditUserDeleted}
+
+	err = models.User.Delete(user.ID, bad)
+	if err == nil {
+		t.Fatal("Delete succeeded with an invalid audit entry")
+	}
+
+	_, err = models.User.Get(user.ID)
+	if err != nil {
+		t.Errorf("user was deleted although the audit entry wasn't written: %v", err)
+	}
+
+	bad = &AuditEntry{ActorID: -1, TargetUserID: user.ID, Action: AuditUserImpersonated}
+
+	_, err = models.Tokens.NewImpersonation(user.ID, admin.ID, time.Minute, bad)
+	if err == nil {
+		t.Fatal("NewImpersonation succeeded with an invalid audit entry")
+	}
+
+	tokens, err := models.Tokens.GetMetadataForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.Scope == ScopeImpersonation {
+			t.Error("impersonation token was saved although the audit entry wasn't written")
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DatabaseModel reports on the database itself, rather than any one table, for the
+// readiness check.
+type DatabaseModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// Ping() checks that the database can be reached, waiting for at most timeout.
+func (m DatabaseModel) Ping(timeout time.Duration) error {
+	ctx, cancel := m.startQuery("DatabaseModel.Ping", timeout)
+	defer cancel()
+
+	return m.db.PingContext(ctx)
+}
+
+// Stats() returns the statistics of the connection pool.
+func (m DatabaseModel) Stats() sql.DBStats {
+	return m.db.Stats()
+}
+
+// SchemaVersion() returns the version of the last migration applied by the migrate
+// tool, and whether it is dirty, meaning that the migration failed part way through.
+// The version is 0 if no migrations have been applied.
+func (m DatabaseModel) SchemaVersion(timeout time.Duration) (int64, bool, error) {
+
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	ctx, cancel := m.startQuery("DatabaseModel.SchemaVersion", timeout)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+
+	err := m.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/tracing"
+)
+
+// A UserDeletion is a user's request to have their account deleted. Nothing is removed
+// straight away: the account stays usable until ScheduledFor, so that the user can
+// change their mind.
+type UserDeletion struct {
+	UserID       int64     `json:"-"`
+	RequestedAt  time.Time `json:"requested_at"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+}
+
+type UserDeletionModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// Schedule() records that a user's account should be deleted at the given time. If the
+// user has already asked for deletion, the earlier request is kept as it is, so asking
+// again can't push the deletion further back.
+func (m UserDeletionModel) Schedule(userID int64, at time.Time) (*UserDeletion, error) {
+	query := `
+		INSERT INTO user_deletions (user_id, scheduled_for)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET user_id = EXCLUDED.user_id
+		RETURNING user_id, requested_at, scheduled_for`
+
+	var deletion UserDeletion
+
+	ctx, cancel := m.startQuery("UserDeletionModel.Schedule", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, userID, at).Scan(
+		&deletion.UserID,
+		&deletion.RequestedAt,
+		&deletion.ScheduledFor,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+// Get() returns the pending deletion for a user, or ErrRecordNotFound if there isn't
+// one.
+func (m UserDeletionModel) Get(userID int64) (*UserDeletion, error) {
+	query := `
+		SELECT user_id, requested_at, scheduled_for
+		FROM user_deletions
+		WHERE user_id = $1`
+
+	var deletion UserDeletion
+
+	ctx, cancel := m.startQuery("UserDeletionModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, userID).Scan(
+		&deletion.UserID,
+		&deletion.RequestedAt,
+		&deletion.ScheduledFor,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &deletion, nil
+}
+
+// Cancel() discards a user's pending deletion. It returns ErrRecordNotFound if there
+// isn't one.
+func (m UserDeletionModel) Cancel(userID int64) error {
+	query := `
+		DELETE FROM user_deletions
+		WHERE user_id = $1`
+
+	ctx, cancel := m.startQuery("UserDeletionModel.Cancel", 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Complete() carries out a user's deletion: it strips their personal details from the
+// audit log and deletes the account, which also removes the pending deletion through
+// the foreign key. Both happen in one transaction, so if either fails nothing changes
+// and the deletion is still due the next time the job runs. It returns
+// ErrRecordNotFound if the user no longer exists.
+func (m UserDeletionModel) Complete(userID int64) error {
+	ctx, cancel := m.startQuery("UserDeletionModel.Complete", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = anonymizeAudit(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = deleteUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDue() returns up to limit deletions whose grace period has ended, oldest first.
+func (m UserDeletionModel) GetDue(ctx context.Context, limit int) ([]*UserDeletion, error) {
+	query := `
+		SELECT user_id, requested_at, scheduled_for
+		FROM user_deletions
+		WHERE scheduled_for <= NOW()
+		ORDER BY scheduled_for
+		LIMIT $1`
+
+	ctx, span := tracing.Start(ctx, "UserDeletionModel.GetDue")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []*UserDeletion{}
+	for rows.Next() {
+		var deletion UserDeletion
+
+		err := rows.Scan(
+			&deletion.UserID,
+			&deletion.RequestedAt,
+			&deletion.ScheduledFor,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, &deletion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deletions, nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompleteDeletion(t *testing.T) {
+	models := newTestModels(t)
+	admin := insertTestUser(t, models)
+	user := insertTestUser(t, models)
+
+	err := models.Audit.Insert(&AuditEntry{
+		ActorID:      admin.ID,
+		TargetUserID: user.ID,
+		Action:       AuditUserUnlocked,
+		Details:      map[string]any{"email": user.Email, "reason": "support request"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Deletions.Schedule(user.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Deletions.Complete(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.User.Get(user.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("user still exists: %v", err)
+	}
+
+	_, err = models.Deletions.Get(user.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("deletion is still pending: %v", err)
+	}
+
+	// The audit entry is kept, without the email address.
+	entries, err := models.Audit.GetAllForTarget(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries; want 1", len(entries))
+	}
+	if _, ok := entries[0].Details["email"]; ok {
+		t.Error("audit entry still holds the email address")
+	}
+	if entries[0].Details["reason"] != "support request" {
+		t.Errorf("audit details = %v; want the other details kept", entries[0].Details)
+	}
+
+	// Completing it again finds nothing to do.
+	err = models.Deletions.Complete(user.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second Complete: got %v; want ErrRecordNotFound", err)
+	}
+}
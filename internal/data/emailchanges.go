@@ -0,0 +1,81 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// An EmailChange is a request to change a user's email address which hasn't been
+// confirmed yet. The user's email is only replaced once they prove that they control
+// the new address, so until then the pending address is kept here.
+type EmailChange struct {
+	UserID    int64
+	CreatedAt time.Time
+	Email     string
+}
+
+type EmailChangeModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// Set() records a pending email address for the user, replacing any earlier request
+// which hasn't been confirmed.
+func (m EmailChangeModel) Set(userID int64, email string) error {
+	query := `
+		INSERT INTO email_changes (user_id, email)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET created_at = NOW(), email = EXCLUDED.email`
+
+	ctx, cancel := m.startQuery("EmailChangeModel.Set", 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// Get() returns the pending email change for a user, or ErrRecordNotFound if there
+// isn't one.
+func (m EmailChangeModel) Get(userID int64) (*EmailChange, error) {
+	query := `
+		SELECT user_id, created_at, email
+		FROM email_changes
+		WHERE user_id = $1`
+
+	var change EmailChange
+
+	ctx, cancel := m.startQuery("EmailChangeModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, userID).Scan(
+		&change.UserID,
+		&change.CreatedAt,
+		&change.Email,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &change, nil
+}
+
+// Delete() discards the pending email change for a user, if there is one.
+func (m EmailChangeModel) Delete(userID int64) error {
+	query := `
+		DELETE FROM email_changes
+		WHERE user_id = $1`
+
+	ctx, cancel := m.startQuery("EmailChangeModel.Delete", 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, userID)
+	return err
+}
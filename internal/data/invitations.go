@@ -0,0 +1,205 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// An Invitation lets someone create an account when public signup is disabled. The
+// account is created with the invitation's email address and permission codes, and is
+// activated straight away, since following the emailed token proves the address.
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Email       string      `json:"email"`
+	InvitedBy   int64       `json:"invited_by,omitempty"` // 0 if the inviter has been deleted
+	Permissions Permissions `json:"permissions"`
+	Plaintext   string      `json:"-"`
+	Hash        []byte      `json:"-"`
+	Expiry      time.Time   `json:"expiry"`
+}
+
+type InvitationModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// New() creates an invitation for the email address, replacing any earlier invitation
+// for the same address, whose token then stops working. The invitation is recorded in
+// the audit log in the same transaction, with its expiry added to the entry's details.
+func (m InvitationModel) New(email string, invitedBy int64, permissions Permissions, ttl time.Duration, audit *AuditEntry) (*Invitation, error) {
+
+	plaintext, hash, err := randomSecret(16)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &Invitation{
+		Email:       email,
+		InvitedBy:   invitedBy,
+		Permissions: permissions,
+		Plaintext:   plaintext,
+		Hash:        hash,
+		Expiry:      time.Now().Add(ttl),
+	}
+
+	if audit != nil {
+		if audit.Details == nil {
+			audit.Details = make(map[string]any)
+		}
+		audit.Details["expiry"] = invitation.Expiry
+	}
+
+	query := `
+		INSERT INTO invitations (email, invited_by, permissions, hash, expiry)
+			VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+		ON CONFLICT (email) DO UPDATE
+			SET created_at = NOW(),
+				invited_by = EXCLUDED.invited_by,
+				permissions = EXCLUDED.permissions,
+				hash = EXCLUDED.hash,
+				expiry = EXCLUDED.expiry
+		RETURNING id, created_at`
+
+	args := []any{
+		invitation.Email,
+		invitation.InvitedBy,
+		pq.Array([]string(invitation.Permissions)),
+		invitation.Hash,
+		invitation.Expiry,
+	}
+
+	ctx, cancel := m.startQuery("InvitationModel.New", 3*time.Second)
+	defer cancel()
+
+	err = auditedTx(ctx, m.db, audit, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// GetForToken() returns the unexpired invitation for a token, or ErrRecordNotFound.
+func (m InvitationModel) GetForToken(plaintext string) (*Invitation, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		SELECT id, created_at, email, COALESCE(invited_by, 0), permissions, expiry
+		FROM invitations
+		WHERE hash = $1 AND expiry > $2`
+
+	var invitation Invitation
+
+	ctx, cancel := m.startQuery("InvitationModel.GetForToken", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		&invitation.InvitedBy,
+		pq.Array((*[]string)(&invitation.Permissions)),
+		&invitation.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Accept() creates the invited user, grants them the invitation's permission codes and
+// uses up the invitation, all in one transaction, so that a token can't be accepted
+// twice. The grant is recorded in the audit log in the same transaction, with the
+// inviter as the actor, since they chose the permissions. The earlier user.invited
+// entries for the address are given the new user as their target, so that they are
+// listed with the user's history and anonymized along with it.
+//
+// The user's email address is taken from the invitation. It returns
+// ErrRecordNotFound if the invitation has expired or been used or replaced, and
+// ErrDuplicateEmail if someone has registered the address in the meantime.
+func (m InvitationModel) Accept(plaintext string, user *User) error {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := m.startQuery("InvitationModel.Accept", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var invitation Invitation
+
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM invitations
+		WHERE hash = $1 AND expiry > $2
+		RETURNING id, email, COALESCE(invited_by, 0), permissions`, hash[:], time.Now()).Scan(
+		&invitation.ID,
+		&user.Email,
+		&invitation.InvitedBy,
+		pq.Array((*[]string)(&invitation.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	user.Activated = true
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users_permissions
+			SELECT $1, permissions.id
+			FROM permissions
+			WHERE permissions.code = ANY($2)`, user.ID, pq.Array([]string(invitation.Permissions)))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE audit_log
+		SET target_user_id = $1
+		WHERE action = $2 AND target_user_id IS NULL AND lower(details->>'email') = lower($3)`,
+		user.ID, AuditUserInvited, user.Email)
+	if err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, &AuditEntry{
+		ActorID:      invitation.InvitedBy,
+		TargetUserID: user.ID,
+		Action:       AuditUserInvitationAccepted,
+		Details: map[string]any{
+			"invitation_id": invitation.ID,
+			"permissions":   invitation.Permissions,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
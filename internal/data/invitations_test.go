@@ -0,0 +1,64 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInvitationIsAudited(t *testing.T) {
+	models := newTestModels(t)
+	admin := insertTestUser(t, models)
+
+	email := fmt.Sprintf("invitee-%d@example.com", time.Now().UnixNano())
+	permissions := Permissions{"movie:read"}
+
+	invitation, err := models.Invitations.New(email, admin.ID, permissions, time.Hour, &AuditEntry{
+		ActorID: admin.ID,
+		Action:  AuditUserInvited,
+		Details: map[string]any{"email": email, "permissions": permissions},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &User{Name: "Invitee"}
+	user.Password.hash = []byte("not a real hash")
+
+	err = models.Invitations.Accept(invitation.Plaintext, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new user is granted the invitation's permission codes.
+	granted, err := models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !granted.Include("movie:read") {
+		t.Errorf("permissions = %v; want them to include movie:read", granted)
+	}
+
+	// Both the invitation and its acceptance are listed under the new user, newest
+	// first, with the inviter as the actor.
+	entries, err := models.Audit.GetAllForTarget(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries; want 2", len(entries))
+	}
+
+	for i, action := range []string{AuditUserInvitationAccepted, AuditUserInvited} {
+		if entries[i].Action != action {
+			t.Errorf("entry %d action = %q; want %q", i, entries[i].Action, action)
+		}
+		if entries[i].ActorID != admin.ID {
+			t.Errorf("entry %d actor = %d; want the inviter %d", i, entries[i].ActorID, admin.ID)
+		}
+	}
+
+	if _, ok := entries[1].Details["expiry"]; !ok {
+		t.Error("invitation entry has no expiry")
+	}
+}
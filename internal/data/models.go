@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/tracing"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -36,20 +40,105 @@ type Models struct {
 	// 	Update(movie *Movie) error
 	// 	Delete(id int64) error
 	// }
-	Movie       MovieModel
-	User        UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movie         MovieModel
+	User          UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	APIKeys       APIKeyModel
+	TOTP          TOTPModel
+	EmailChanges  EmailChangeModel
+	Roles         RoleModel
+	Audit         AuditModel
+	OAuthClients  OAuthClientModel
+	OAuthCodes    OAuthCodeModel
+	OAuthConsents OAuthConsentModel
+	Deletions     UserDeletionModel
+	Invitations   InvitationModel
+	Database      DatabaseModel
 	// other db models should go here
 }
 
 // constructor for instanciate the model
 func NewModels(db *sql.DB) *Models {
 	return &Models{
-		Movie:       MovieModel{db: db},
-		User:        UserModel{db: db},
-		Tokens:      TokenModel{db: db},
-		Permissions: PermissionModel{DB: db},
+		Movie:         MovieModel{db: db},
+		User:          UserModel{db: db},
+		Tokens:        TokenModel{db: db},
+		Permissions:   PermissionModel{DB: db},
+		APIKeys:       APIKeyModel{db: db},
+		TOTP:          TOTPModel{db: db},
+		EmailChanges:  EmailChangeModel{db: db},
+		Roles:         RoleModel{DB: db},
+		Audit:         AuditModel{db: db},
+		OAuthClients:  OAuthClientModel{db: db},
+		OAuthCodes:    OAuthCodeModel{db: db},
+		OAuthConsents: OAuthConsentModel{db: db},
+		Deletions:     UserDeletionModel{db: db},
+		Invitations:   InvitationModel{db: db},
+		Database:      DatabaseModel{db: db},
 		// other db models should go here
 	}
 }
+
+// WithContext() returns a copy of the models whose queries are traced as part of ctx,
+// usually the context of the request that they are run for. The models returned by
+// NewModels() start a new trace for every query.
+func (m Models) WithContext(ctx context.Context) *Models {
+	t := queryTrace{parent: ctx}
+
+	m.Movie.queryTrace = t
+	m.User.queryTrace = t
+	m.Tokens.queryTrace = t
+	m.Permissions.queryTrace = t
+	m.APIKeys.queryTrace = t
+	m.TOTP.queryTrace = t
+	m.EmailChanges.queryTrace = t
+	m.Roles.queryTrace = t
+	m.Audit.queryTrace = t
+	m.OAuthClients.queryTrace = t
+	m.OAuthCodes.queryTrace = t
+	m.OAuthConsents.queryTrace = t
+	m.Deletions.queryTrace = t
+	m.Invitations.queryTrace = t
+	m.Database.queryTrace = t
+
+	return &m
+}
+
+// queryTrace is embedded in every model, and holds the context that its queries are
+// traced as part of.
+type queryTrace struct {
+	parent context.Context
+}
+
+// startQuery() returns the context to run a query with, which times out after timeout,
+// and starts a span for the query. The returned cancel function also ends the span.
+//
+// The query isn't cancelled along with the parent context. Queries have always run with
+// their own timeout, and some are run from background goroutines after the request
+// which started them has finished.
+func (t queryTrace) startQuery(name string, timeout time.Duration) (context.Context, context.CancelFunc) {
+	parent := t.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, span := tracing.Start(context.WithoutCancel(parent), name)
+	span.SetAttr("db.system", "postgresql")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		// A query which ran out of time shows up as a failed span.
+		span.RecordError(ctx.Err())
+		cancel()
+		span.End()
+	}
+}
+
+// UsePermissionCache() puts a cache in front of permission lookups. The models which
+// change permissions share it, so that they can invalidate it.
+func (m *Models) UsePermissionCache(cache *PermissionCache) {
+	m.Permissions.Cache = cache
+	m.Roles.Cache = cache
+}
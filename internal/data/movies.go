@@ -1,7 +1,6 @@
 package data
 
 import (
-	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -19,10 +18,18 @@ type Movie struct {
 	Genres    []string  `json:"genres"`
 	Year      int32     `json:"year,omitempty"`
 	Version   int32     `json:"version"`
+	CreatedBy int64     `json:"created_by,omitempty"` // 0 if the creator isn't known
+}
+
+// OwnerID() returns the ID of the user who created the movie, so that policy.Rule can
+// check ownership.
+func (m *Movie) OwnerID() int64 {
+	return m.CreatedBy
 }
 
 type MovieModel struct {
 	db *sql.DB
+	queryTrace
 }
 
 type MockMovieModel struct{}
@@ -32,14 +39,17 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	query :=
 		`
-		INSERT INTO movie (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movie (title, year, runtime, genres, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
 		RETURNING id, created_at, version
 		`
 
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy}
+
+	ctx, cancel := m.startQuery("MovieModel.Insert", 3*time.Second)
+	defer cancel()
 
-	return m.db.QueryRow(query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
 // GetAll() mehtod for returning list of data
@@ -48,7 +58,7 @@ func (m MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie,
 	query := fmt.Sprintf(
 		`
 		SELECT 
-			 count(*) OVER(), id, created_at, title, year, runtime, genres, version
+			 count(*) OVER(), id, created_at, title, year, runtime, genres, version, COALESCE(created_by, 0)
 		FROM movie
 		WHERE 
 			(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
@@ -59,7 +69,7 @@ func (m MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie,
 		`, f.sortColumn(), f.sortDirection())
 
 	// create context with one mint timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := m.startQuery("MovieModel.GetAll", 60*time.Second)
 
 	defer cancel()
 
@@ -92,6 +102,7 @@ func (m MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.CreatedBy,
 		)
 		if err != nil {
 			return nil, Metadata{}, nil
@@ -122,15 +133,18 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	var movie Movie
 
 	query :=
-		` 	SELECT * 
+		` 	SELECT id, created_at, title, year, runtime, genres, version, COALESCE(created_by, 0)
 					from movie
 			WHERE 
 				id = $1
 		`
+	ctx, cancel := m.startQuery("MovieModel.Get", 3*time.Second)
+	defer cancel()
+
 	// execute and unpacked the data
-	//! caution: scan order should match the db columns order,
+	//! caution: scan order should match the order of the selected columns,
 	// otherwise will get a `[pq: cannot convert]` eroor
-	err := m.db.QueryRow(query, id).Scan(
+	err := m.db.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -138,6 +152,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.CreatedBy,
 	)
 
 	if err != nil {
@@ -153,6 +168,50 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
+// GetAllForCreator() returns every movie created by a user, oldest first.
+func (m MovieModel) GetAllForCreator(userID int64) ([]*Movie, error) {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version, COALESCE(created_by, 0)
+		FROM movie
+		WHERE created_by = $1
+		ORDER BY id`
+
+	ctx, cancel := m.startQuery("MovieModel.GetAllForCreator", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
 // Add a placeholder method for updating a specific record in the movies table.
 func (m MovieModel) Update(movie *Movie) error {
 
@@ -176,7 +235,10 @@ func (m MovieModel) Update(movie *Movie) error {
 		&movie.ID,
 	}
 
-	return m.db.QueryRow(query, args...).Scan(&movie.Version)
+	ctx, cancel := m.startQuery("MovieModel.Update", 3*time.Second)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 }
 
 // Add a placeholder method for deleting a specific record from the movies table.
@@ -192,8 +254,11 @@ func (m MovieModel) Delete(id int64) error {
 		WHERE id = $1
 		`
 
+	ctx, cancel := m.startQuery("MovieModel.Delete", 3*time.Second)
+	defer cancel()
+
 	// execute the db operation
-	result, err := m.db.Exec(query, id)
+	result, err := m.db.ExecContext(ctx, query, id)
 
 	if err != nil {
 		return err
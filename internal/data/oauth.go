@@ -0,0 +1,474 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// An OAuthClient is a third-party application which can act on behalf of our users
+// once they have agreed to it. Confidential clients run on a server and authenticate
+// with a secret. Public clients, such as single page and mobile apps, can't keep a
+// secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string      `json:"client_id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UserID       int64       `json:"-"`
+	Name         string      `json:"name"`
+	Secret       string      `json:"client_secret,omitempty"`
+	SecretHash   []byte      `json:"-"`
+	Confidential bool        `json:"confidential"`
+	RedirectURIs []string    `json:"redirect_uris"`
+	Permissions  Permissions `json:"permissions"`
+}
+
+// An OAuthCode is an authorization code, which a client exchanges for an access token.
+type OAuthCode struct {
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Permissions   Permissions
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+// An OAuthConsent records the permission codes that a user has let a client use.
+type OAuthConsent struct {
+	ClientID    string      `json:"client_id"`
+	ClientName  string      `json:"client_name"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type OAuthClientModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+type OAuthCodeModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+type OAuthConsentModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// randomSecret() returns a random unpadded base-32 string made from n random bytes,
+// along with its SHA-256 hash.
+func randomSecret(n int) (string, []byte, error) {
+	randomBytes := make([]byte, n)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
+
+// SecretMatches() reports whether the secret is the client's. It always fails for a
+// public client, which doesn't have one.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.Confidential {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// AllowsRedirectURI() reports whether the URI is one that the client registered. Only
+// exact matches count, so that an attacker can't steer codes to a URI they control.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// ValidateOAuthClient checks the user-supplied fields of a new client. The granted
+// slice holds the permission codes that the developer registering the client currently
+// has, and the client may only ask for codes from that slice.
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient, granted Permissions) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 redirect URI")
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 redirect URIs")
+	v.Check(validator.Unique(client.RedirectURIs), "redirect_uris", "must not contain duplicate values")
+	for _, uri := range client.RedirectURIs {
+		v.Check(validRedirectURI(uri), "redirect_uris", "must be absolute https URLs without a fragment, or http URLs on a loopback address")
+	}
+
+	v.Check(len(client.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(client.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range client.Permissions {
+		v.Check(granted.Include(code), "permissions", "must only contain permissions that you have been granted")
+	}
+}
+
+// validRedirectURI() follows RFC 8252: redirect URIs must use https, except for native
+// apps listening on a loopback address.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// Insert() gives the client a new ID, and a new secret if it's confidential, and then
+// stores it. The plaintext secret is only available in the Secret field afterwards.
+func (m OAuthClientModel) Insert(client *OAuthClient) error {
+
+	id, _, err := randomSecret(16)
+	if err != nil {
+		return err
+	}
+	client.ID = id
+
+	if client.Confidential {
+		client.Secret, client.SecretHash, err = randomSecret(32)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, permissions)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	args := []any{
+		client.ID,
+		client.UserID,
+		client.Name,
+		client.SecretHash,
+		pq.Array(client.RedirectURIs),
+		pq.Array([]string(client.Permissions)),
+	}
+
+	ctx, cancel := m.startQuery("OAuthClientModel.Insert", 3*time.Second)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(&client.CreatedAt)
+}
+
+const oauthClientColumns = `id, created_at, user_id, name, secret_hash, redirect_uris, permissions`
+
+func scanOAuthClient(row interface{ Scan(...any) error }) (*OAuthClient, error) {
+	var client OAuthClient
+
+	err := row.Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.UserID,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.RedirectURIs),
+		pq.Array((*[]string)(&client.Permissions)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.Confidential = client.SecretHash != nil
+
+	return &client, nil
+}
+
+// Get() returns the client with the given ID.
+func (m OAuthClientModel) Get(id string) (*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE id = $1`
+
+	ctx, cancel := m.startQuery("OAuthClientModel.Get", 3*time.Second)
+	defer cancel()
+
+	client, err := scanOAuthClient(m.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// GetAllForUser() returns the clients registered by a user.
+func (m OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE user_id = $1 ORDER BY created_at`
+
+	ctx, cancel := m.startQuery("OAuthClientModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteForUser() deletes a client, but only if it was registered by the given user.
+// Its codes, consents and access tokens go with it.
+func (m OAuthClientModel) DeleteForUser(id string, userID int64) error {
+	query := `
+		DELETE FROM oauth_clients
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := m.startQuery("OAuthClientModel.DeleteForUser", 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// New() creates an authorization code and returns its plaintext.
+func (m OAuthCodeModel) New(code *OAuthCode, ttl time.Duration) (string, error) {
+
+	plaintext, hash, err := randomSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	code.Expiry = time.Now().Add(ttl)
+
+	query := `
+		INSERT INTO oauth_codes (hash, client_id, user_id, redirect_uri, permissions, code_challenge, expiry)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []any{
+		hash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array([]string(code.Permissions)),
+		code.CodeChallenge,
+		code.Expiry,
+	}
+
+	ctx, cancel := m.startQuery("OAuthCodeModel.New", 3*time.Second)
+	defer cancel()
+
+	_, err = m.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// Consume() looks up an authorization code and deletes it in the same statement, so
+// that each code can be redeemed at most once even if two requests race. An expired
+// code is deleted too, but reported as ErrRecordNotFound.
+func (m OAuthCodeModel) Consume(plaintext string) (*OAuthCode, error) {
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		DELETE FROM oauth_codes
+		WHERE hash = $1
+		RETURNING client_id, user_id, redirect_uri, permissions, code_challenge, expiry`
+
+	var code OAuthCode
+
+	ctx, cancel := m.startQuery("OAuthCodeModel.Consume", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, hash[:]).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array((*[]string)(&code.Permissions)),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(code.Expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &code, nil
+}
+
+// Get() returns the permission codes that a user has let a client use, or
+// ErrRecordNotFound if they have never agreed to anything.
+func (m OAuthConsentModel) Get(userID int64, clientID string) (Permissions, error) {
+	query := `
+		SELECT permissions
+		FROM oauth_consents
+		WHERE user_id = $1 AND client_id = $2`
+
+	var permissions Permissions
+
+	ctx, cancel := m.startQuery("OAuthConsentModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, userID, clientID).Scan(pq.Array((*[]string)(&permissions)))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return permissions, nil
+}
+
+// Grant() adds permission codes to those that a user has let a client use.
+func (m OAuthConsentModel) Grant(userID int64, clientID string, permissions Permissions) error {
+	query := `
+		INSERT INTO oauth_consents (user_id, client_id, permissions)
+			VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE
+			SET permissions = ARRAY(
+				SELECT DISTINCT unnest(oauth_consents.permissions || EXCLUDED.permissions)
+				ORDER BY 1
+			)`
+
+	ctx, cancel := m.startQuery("OAuthConsentModel.Grant", 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, userID, clientID, pq.Array([]string(permissions)))
+	return err
+}
+
+// GetAllForUser() returns every client that a user has agreed to let act for them.
+func (m OAuthConsentModel) GetAllForUser(userID int64) ([]*OAuthConsent, error) {
+	query := `
+		SELECT oauth_consents.client_id, oauth_clients.name, oauth_consents.created_at, oauth_consents.permissions
+		FROM oauth_consents
+			INNER JOIN oauth_clients ON oauth_clients.id = oauth_consents.client_id
+		WHERE oauth_consents.user_id = $1
+		ORDER BY oauth_consents.created_at`
+
+	ctx, cancel := m.startQuery("OAuthConsentModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []*OAuthConsent{}
+	for rows.Next() {
+		var consent OAuthConsent
+
+		err := rows.Scan(
+			&consent.ClientID,
+			&consent.ClientName,
+			&consent.CreatedAt,
+			pq.Array((*[]string)(&consent.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		consents = append(consents, &consent)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return consents, nil
+}
+
+// Delete() withdraws a user's consent for a client, and revokes every access token
+// that the client holds for the user, in a single transaction.
+func (m OAuthConsentModel) Delete(userID int64, clientID string) error {
+
+	ctx, cancel := m.startQuery("OAuthConsentModel.Delete", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM oauth_consents WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// permissionsChannel is the Postgres NOTIFY channel used to tell every API instance
+// that a user's permissions have changed. The payload is the user ID.
+const permissionsChannel = "permissions_changed"
+
+// A PermissionCache holds recently looked up permission codes in memory, so that
+// requirePermission() doesn't have to query the database on every request. Entries
+// expire after the TTL, which bounds how long a change made elsewhere can go unnoticed
+// even if a notification is lost.
+//
+// A nil *PermissionCache is valid and caches nothing.
+type PermissionCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[int64]permissionCacheEntry
+	// generation is bumped by every invalidation. A lookup which started before an
+	// invalidation must not store its (possibly stale) result afterwards.
+	generation uint64
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expires     time.Time
+}
+
+// PermissionCacheStats is a snapshot of the cache counters.
+type PermissionCacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Evictions counts the entries dropped to make room for new ones.
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+// NewPermissionCache returns a cache which holds up to maxSize users for at most ttl.
+func NewPermissionCache(ttl time.Duration, maxSize int) *PermissionCache {
+	return &PermissionCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+}
+
+// get() returns the cached permissions for a user. On a miss it also returns the
+// current generation, which must be passed to set() along with the fresh value.
+func (c *PermissionCache) get(userID int64) (Permissions, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[userID]
+	if ok && time.Now().Before(entry.expires) {
+		c.hits.Add(1)
+		return slices.Clone(entry.permissions), c.generation, true
+	}
+
+	c.misses.Add(1)
+	return nil, c.generation, false
+}
+
+func (c *PermissionCache) set(userID int64, permissions Permissions, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if _, ok := c.entries[userID]; !ok && len(c.entries) >= c.maxSize {
+		c.evict()
+	}
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: slices.Clone(permissions),
+		expires:     time.Now().Add(c.ttl),
+	}
+}
+
+// evict() makes room for a new entry, by dropping the expired entries or, if there
+// aren't any, an arbitrary one. The caller must hold the lock.
+func (c *PermissionCache) evict() {
+	now := time.Now()
+	for id, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, id)
+			c.evictions.Add(1)
+		}
+	}
+
+	if len(c.entries) < c.maxSize {
+		return
+	}
+
+	for id := range c.entries {
+		delete(c.entries, id)
+		c.evictions.Add(1)
+		break
+	}
+}
+
+// Invalidate drops the cached permissions for a single user.
+func (c *PermissionCache) Invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.generation++
+}
+
+// Purge drops every cached entry.
+func (c *PermissionCache) Purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.generation++
+}
+
+// Stats returns the current hit, miss and eviction counters and the number of cached
+// users.
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	if c == nil {
+		return PermissionCacheStats{}
+	}
+
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return PermissionCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+// Listen opens a dedicated connection which LISTENs for permission changes made by any
+// API instance and invalidates the affected entries. If the connection drops, every
+// entry is purged once it is re-established, since notifications sent in the meantime
+// are lost. The returned listener should be closed on shutdown.
+func (c *PermissionCache) Listen(dsn string, logger *slog.Logger) (*pq.Listener, error) {
+
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Error("permission cache listener", "error", err)
+		}
+	})
+
+	err := listener.Listen(permissionsChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for n := range listener.Notify {
+			// pq sends a nil notification after it reconnects.
+			if n == nil {
+				c.Purge()
+				continue
+			}
+
+			userID, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				logger.Error("permission cache listener: invalid payload", "payload", n.Extra)
+				c.Purge()
+				continue
+			}
+
+			c.Invalidate(userID)
+		}
+	}()
+
+	return listener, nil
+}
+
+// sendPermissionsNotification() tells every API instance, including this one, that a
+// user's permissions have changed. Sent as part of a transaction, the notification is
+// only delivered if the transaction commits.
+func sendPermissionsNotification(ctx context.Context, q queryer, userID int64) error {
+	_, err := q.ExecContext(ctx, `SELECT pg_notify($1, $2)`, permissionsChannel, strconv.FormatInt(userID, 10))
+	return err
+}
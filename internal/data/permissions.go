@@ -1,7 +1,6 @@
 package data
 
 import (
-	"context"
 	"database/sql"
 	"time"
 
@@ -23,40 +22,152 @@ func (p Permissions) Include(code string) bool {
 
 type PermissionModel struct {
 	DB *sql.DB
+	queryTrace
+	// Cache is optional. When set, GetAllForUser() answers from it where it can, and
+	// every change made through this model invalidates it.
+	Cache *PermissionCache
 }
 
-// Add the provided permission codes for a specific user. Notice that we're using a
-// variadic parameter for the codes so that we can assign multiple permissions in a
-// single call.
-func (m PermissionModel) AddForUser(userId int64, codes ...string) error {
+// Add the provided permission codes to a specific user, and record the change in the
+// audit log in the same transaction. Codes which the user already has are ignored.
+func (m PermissionModel) AddForUser(userID int64, codes []string, audit *AuditEntry) error {
 
 	query :=
 		`INSERT INTO users_permissions
-			 SELECT $1, permissions.id 
-			 FROM permissions 
-			 WHERE permissions.code = ANY($2)`
+			 SELECT $1, permissions.id
+			 FROM permissions
+			 WHERE permissions.code = ANY($2)
+		 ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("PermissionModel.AddForUser", 3*time.Second)
 	defer cancel()
 
-	args := []any{userId, pq.Array(codes)}
+	err := auditedTx(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+		return sendPermissionsNotification(ctx, tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// Remove the provided permission codes from a specific user, and record the change in
+// the audit log in the same transaction. This only removes codes granted directly, and
+// has no effect on codes that the user gets from a role.
+func (m PermissionModel) RemoveForUser(userID int64, codes []string, audit *AuditEntry) error {
+
+	query :=
+		`DELETE FROM users_permissions
+		 USING permissions
+		 WHERE users_permissions.permission_id = permissions.id
+			 AND users_permissions.user_id = $1
+			 AND permissions.code = ANY($2)`
+
+	ctx, cancel := m.startQuery("PermissionModel.RemoveForUser", 3*time.Second)
+	defer cancel()
+
+	err := auditedTx(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+		return sendPermissionsNotification(ctx, tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// GetAll() returns every permission code that exists.
+func (m PermissionModel) GetAll() (Permissions, error) {
+
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := m.startQuery("PermissionModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	return permissions, nil
 }
 
+// GetAllForUser() returns every permission code that a user has, whether it was granted
+// to them directly or through one of their roles. The result comes from the cache if
+// there is a fresh entry for the user.
 func (m PermissionModel) GetAllForUser(userId int64) (Permissions, error) {
 
+	permissions, generation, ok := m.Cache.get(userId)
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err := m.getAllForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Cache.set(userId, permissions, generation)
+
+	return permissions, nil
+}
+
+// getAllForUser() reads a user's permissions from the database. The recursive all_roles
+// CTE walks up the role hierarchy, so a role's permissions include those of all its
+// ancestors. Using UNION rather than UNION ALL removes duplicates, and also stops the
+// recursion if the hierarchy ever contains a cycle.
+func (m PermissionModel) getAllForUser(userId int64) (Permissions, error) {
+
 	query :=
 		`
+		WITH RECURSIVE all_roles(role_id) AS (
+				SELECT users_roles.role_id
+				FROM users_roles
+				WHERE users_roles.user_id = $1
+			UNION
+				SELECT roles_parents.parent_id
+				FROM roles_parents
+					INNER JOIN all_roles ON roles_parents.role_id = all_roles.role_id
+		)
 		SELECT permissions.code
 		FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN all_roles ON roles_permissions.role_id = all_roles.role_id
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("PermissionModel.getAllForUser", 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userId)
@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A role is a named bundle of permission codes, such as "viewer" or "editor". Roles can
+// inherit from other roles, and a user's permissions are the union of the codes granted
+// to them directly and the codes granted to their roles and every role those inherit
+// from. See PermissionModel.GetAllForUser().
+type RoleModel struct {
+	DB *sql.DB
+	queryTrace
+	// Cache is the permission cache to invalidate when a user's roles change.
+	Cache *PermissionCache
+}
+
+// Add the named roles to a specific user. Names which don't match a role are ignored,
+// as are roles which the user already has. Other API instances are told about the
+// change in the same transaction, so they can't miss it.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+
+	ctx, cancel := m.startQuery("RoleModel.AddForUser", 3*time.Second)
+	defer cancel()
+
+	err := auditedTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		err := addRoles(ctx, tx, userID, names)
+		if err != nil {
+			return err
+		}
+		return sendPermissionsNotification(ctx, tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// addRoles() gives a user the named roles with q.
+func addRoles(ctx context.Context, q queryer, userID int64, names []string) error {
+
+	query :=
+		`INSERT INTO users_roles
+			 SELECT $1, roles.id
+			 FROM roles
+			 WHERE roles.name = ANY($2)
+		 ON CONFLICT DO NOTHING`
+
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+// Remove the named roles from a specific user, telling other API instances in the same
+// transaction.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+
+	query :=
+		`DELETE FROM users_roles
+		 USING roles
+		 WHERE users_roles.role_id = roles.id
+			 AND users_roles.user_id = $1
+			 AND roles.name = ANY($2)`
+
+	ctx, cancel := m.startQuery("RoleModel.RemoveForUser", 3*time.Second)
+	defer cancel()
+
+	err := auditedTx(ctx, m.DB, nil, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(names))
+		if err != nil {
+			return err
+		}
+		return sendPermissionsNotification(ctx, tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+	return nil
+}
+
+// GetAllForUser() returns the names of the roles assigned directly to a user. Roles that
+// are only inherited are not included.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+
+	query :=
+		`
+		SELECT roles.name
+		FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name
+		`
+
+	ctx, cancel := m.startQuery("RoleModel.GetAllForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Exists() reports whether a role with the given name has been defined.
+func (m RoleModel) Exists(name string) (bool, error) {
+
+	query := `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`
+
+	ctx, cancel := m.startQuery("RoleModel.Exists", 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&exists)
+	return exists, err
+}
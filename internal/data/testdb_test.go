@@ -0,0 +1,56 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// newTestModels() connects to the database named by GREENLIGHT_TEST_DB_DSN, which must
+// already have the migrations applied, and skips the test if it isn't set. Use a
+// throwaway database: the tests create rows and don't clean all of them up.
+func newTestModels(t *testing.T) *Models {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewModels(db)
+}
+
+// insertTestUser() creates a user with a unique email address. The password hash is a
+// placeholder, so the user can't log in.
+func insertTestUser(t *testing.T, models *Models) *User {
+	t.Helper()
+
+	user := &User{
+		Name:      "Test User",
+		Email:     fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+	}
+	user.Password.hash = []byte("not a real hash")
+
+	err := models.User.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
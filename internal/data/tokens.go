@@ -6,8 +6,10 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
 )
 
@@ -16,6 +18,23 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	// A totp-pending token is issued in place of an authentication token when the
+	// user has two-factor authentication enabled. It can only be exchanged for an
+	// authentication token together with a valid one-time code.
+	ScopeTOTPPending = "totp-pending"
+	// Changing email address uses two tokens. The email-change token is sent to the
+	// new address and confirms the change, while the email-change-cancel token is sent
+	// to the old address so that its owner can stop a change they didn't ask for.
+	ScopeEmailChange       = "email-change"
+	ScopeEmailChangeCancel = "email-change-cancel"
+	// An oauth-access token is issued to an OAuth client. It records the client and
+	// the permission codes that it is limited to. These tokens start with
+	// OAuthAccessTokenPrefix, which is how authenticate() tells them apart.
+	ScopeOAuthAccess = "oauth-access"
+	// An impersonation token lets an administrator see the API as another user does.
+	// It authenticates as that user, and records the administrator as its
+	// impersonator.
+	ScopeImpersonation = "impersonation"
 )
 
 // Define a Token struct to hold the data for an individual token. This includes the
@@ -27,11 +46,17 @@ type Token struct {
 	UserId    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// ClientID and Permissions are only set for OAuth access tokens.
+	ClientID    string      `json:"-"`
+	Permissions Permissions `json:"-"`
+	// ImpersonatorID is only set for impersonation tokens.
+	ImpersonatorID int64 `json:"-"`
 }
 
 // tokenModel for database dependency
 type TokenModel struct {
 	db *sql.DB
+	queryTrace
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -98,21 +123,30 @@ func (m *TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token,
 }
 
 func (m *TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO TOKENS (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
-	`
+	ctx, cancel := m.startQuery("TokenModel.Insert", 3*time.Second)
+	defer cancel()
 
-	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope}
+	return insertToken(ctx, m.db, token)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// insertToken() writes a token to the tokens table with q.
+func insertToken(ctx context.Context, q queryer, token *Token) error {
+	query := `INSERT INTO TOKENS (hash, user_id, expiry, scope, client_id, permissions, impersonator_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NULLIF($7, 0))
+	`
 
-	_, err := m.db.ExecContext(ctx, query, args...)
-	if err != nil {
-		return err
+	args := []any{
+		token.Hash,
+		token.UserId,
+		token.Expiry,
+		token.Scope,
+		token.ClientID,
+		pq.Array([]string(token.Permissions)),
+		token.ImpersonatorID,
 	}
 
-	return nil
+	_, err := q.ExecContext(ctx, query, args...)
+	return err
 }
 
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
@@ -122,9 +156,150 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 		tokens
 			WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("TokenModel.DeleteAllForUser", 3*time.Second)
 	defer cancel()
 	_, err := m.db.ExecContext(ctx, query, scope, userID)
 
 	return err
 }
+
+// OAuthAccessTokenPrefix starts every OAuth access token. Our other bearer tokens are
+// upper-case base-32, so they can never start with it.
+const OAuthAccessTokenPrefix = "gl_oat_"
+
+// IsOAuthAccessToken() reports whether a bearer token is an OAuth access token, rather
+// than one of our own authentication tokens.
+func IsOAuthAccessToken(plaintext string) bool {
+	return strings.HasPrefix(plaintext, OAuthAccessTokenPrefix)
+}
+
+// NewOAuth() creates an access token for an OAuth client, acting for the given user and
+// limited to the given permission codes.
+func (m *TokenModel) NewOAuth(userID int64, ttl time.Duration, clientID string, permissions Permissions) (*Token, error) {
+
+	secret, _, err := randomSecret(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stored hash covers the prefix too, since that's what the client sends back.
+	plaintext := OAuthAccessTokenPrefix + secret
+	hash := sha256.Sum256([]byte(plaintext))
+
+	token := &Token{
+		Plaintext:   plaintext,
+		Hash:        hash[:],
+		UserId:      userID,
+		Expiry:      time.Now().Add(ttl),
+		Scope:       ScopeOAuthAccess,
+		ClientID:    clientID,
+		Permissions: permissions,
+	}
+
+	err = m.Insert(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// DeleteOAuth() revokes an OAuth access token, but only if it was issued to the given
+// client. Revoking a token which doesn't exist is not an error.
+func (m TokenModel) DeleteOAuth(plaintext, clientID string) error {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND client_id = $3`
+
+	ctx, cancel := m.startQuery("TokenModel.DeleteOAuth", 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, hash[:], ScopeOAuthAccess, clientID)
+	return err
+}
+
+// NewImpersonation() creates a token which authenticates as the target user on behalf of
+// the impersonating administrator, and records it in the audit log in the same
+// transaction. The token's expiry is added to the entry's details.
+func (m *TokenModel) NewImpersonation(targetID, impersonatorID int64, ttl time.Duration, audit *AuditEntry) (*Token, error) {
+
+	token, err := generateToken(targetID, ttl, ScopeImpersonation)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ImpersonatorID = impersonatorID
+
+	if audit != nil {
+		if audit.Details == nil {
+			audit.Details = make(map[string]any)
+		}
+		audit.Details["expiry"] = token.Expiry
+	}
+
+	ctx, cancel := m.startQuery("TokenModel.NewImpersonation", 3*time.Second)
+	defer cancel()
+
+	err = auditedTx(ctx, m.db, audit, func(tx *sql.Tx) error {
+		return insertToken(ctx, tx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// TokenMetadata describes a token without the token itself, for showing a user which
+// tokens exist for their account.
+type TokenMetadata struct {
+	Scope          string      `json:"scope"`
+	Expiry         time.Time   `json:"expiry"`
+	ClientID       string      `json:"client_id,omitempty"`
+	Permissions    Permissions `json:"permissions,omitempty"`
+	ImpersonatorID int64       `json:"impersonator_id,omitempty"`
+}
+
+// GetMetadataForUser() returns the metadata of every unexpired token belonging to a
+// user. The hashes are never selected.
+func (m TokenModel) GetMetadataForUser(userID int64) ([]*TokenMetadata, error) {
+	query := `
+		SELECT scope, expiry, COALESCE(client_id, ''), permissions, COALESCE(impersonator_id, 0)
+		FROM tokens
+		WHERE user_id = $1 AND expiry > $2
+		ORDER BY expiry`
+
+	ctx, cancel := m.startQuery("TokenModel.GetMetadataForUser", 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*TokenMetadata{}
+	for rows.Next() {
+		var token TokenMetadata
+
+		err := rows.Scan(
+			&token.Scope,
+			&token.Expiry,
+			&token.ClientID,
+			pq.Array((*[]string)(&token.Permissions)),
+			&token.ImpersonatorID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
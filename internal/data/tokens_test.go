@@ -0,0 +1,31 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsOAuthAccessToken(t *testing.T) {
+	// Our own tokens are never taken for OAuth access tokens, whatever their content.
+	for i := 0; i < 100; i++ {
+		token, err := generateToken(1, time.Hour, ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsOAuthAccessToken(token.Plaintext) {
+			t.Fatalf("authentication token %q was taken for an OAuth access token", token.Plaintext)
+		}
+	}
+
+	secret, _, err := randomSecret(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsOAuthAccessToken(OAuthAccessTokenPrefix + secret) {
+		t.Error("OAuth access token wasn't recognised")
+	}
+	if IsOAuthAccessToken(secret) {
+		t.Error("a 52-character token without the prefix was taken for an OAuth access token")
+	}
+}
@@ -0,0 +1,210 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/totp"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// The number of single-use recovery codes issued when two-factor authentication is
+// enabled.
+const recoveryCodeCount = 10
+
+// TOTP holds a user's two-factor authentication settings. The secret is only enabled
+// once the user has proved that their authenticator app generates the right codes.
+// LastUsedStep records the time step of the last accepted code, so that an intercepted
+// code can't be replayed while it is still valid.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       []byte
+	Enabled      bool
+	LastUsedStep int64
+}
+
+type TOTPModel struct {
+	db *sql.DB
+	queryTrace
+}
+
+// Check that a one-time code has been provided and looks like a TOTP code.
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// Get() returns the two-factor settings for a user, or ErrRecordNotFound if they have
+// never started enrollment.
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, enabled, last_used_step
+		FROM users_totp
+		WHERE user_id = $1`
+
+	var t TOTP
+
+	ctx, cancel := m.startQuery("TOTPModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.CreatedAt,
+		&t.Secret,
+		&t.Enabled,
+		&t.LastUsedStep,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Enroll() stores a new, not yet enabled, secret for the user. Starting enrollment again
+// before confirming simply replaces the previous secret.
+func (m TOTPModel) Enroll(userID int64, secret []byte) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+			VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET created_at = NOW(), secret = EXCLUDED.secret, enabled = FALSE, last_used_step = 0`
+
+	ctx, cancel := m.startQuery("TOTPModel.Enroll", 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, query, userID, secret)
+	return err
+}
+
+// Enable() turns two-factor authentication on for the user, records the step of the
+// code that confirmed it, and replaces any existing recovery codes with a fresh set. The
+// plaintext recovery codes are returned so that they can be shown to the user once.
+func (m TOTPModel) Enable(userID int64, step int64) ([]string, error) {
+	codes, hashes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := m.startQuery("TOTPModel.Enable", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users_totp SET enabled = TRUE, last_used_step = $2 WHERE user_id = $1`,
+		userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO totp_recovery_codes (hash, user_id) VALUES ($1, $2)`,
+			hash, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseStep() records that a code from the given time step has been accepted. It returns
+// false if a code from this step (or a later one) was already used, in which case the
+// code must be rejected.
+func (m TOTPModel) UseStep(userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+			SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := m.startQuery("TOTPModel.UseStep", 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode() consumes one of the user's recovery codes. If the code doesn't exist
+// (or has already been used) it returns ErrRecordNotFound.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) error {
+	query := `
+		DELETE FROM totp_recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := m.startQuery("TOTPModel.UseRecoveryCode", 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, query, totp.HashRecoveryCode(code), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete() turns two-factor authentication off for the user and discards their secret
+// and recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := m.startQuery("TOTPModel.Delete", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	models := newTestModels(t)
+	user := insertTestUser(t, models)
+
+	err := models.TOTP.Enroll(user.ID, []byte("12345678901234567890"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := models.TOTP.Enable(user.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each code can only be used once.
+	err = models.TOTP.UseRecoveryCode(user.ID, codes[0])
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	err = models.TOTP.UseRecoveryCode(user.ID, codes[0])
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("second use: got %v; want ErrRecordNotFound", err)
+	}
+
+	// Using one code doesn't use up the others.
+	err = models.TOTP.UseRecoveryCode(user.ID, codes[1])
+	if err != nil {
+		t.Fatalf("another code: %v", err)
+	}
+
+	// A code belongs to one user.
+	other := insertTestUser(t, models)
+	err = models.TOTP.UseRecoveryCode(other.ID, codes[2])
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("another user's code: got %v; want ErrRecordNotFound", err)
+	}
+}
+
+func TestUseStepRejectsReplay(t *testing.T) {
+	models := newTestModels(t)
+	user := insertTestUser(t, models)
+
+	err := models.TOTP.Enroll(user.ID, []byte("12345678901234567890"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.TOTP.Enable(user.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		step int64
+		ok   bool
+	}{
+		{100, false}, // the step that enabled it
+		{99, false},
+		{101, true},
+		{101, false},
+	} {
+		ok, err := models.TOTP.UseStep(user.ID, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Errorf("UseStep(%d) = %t; want %t", tt.step, ok, tt.ok)
+		}
+	}
+}
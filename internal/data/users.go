@@ -5,14 +5,21 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/passhash"
 	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var AnonymousUser = &User{}
 
+// PasswordHasher hashes new passwords and verifies existing ones. The default hashes
+// with argon2id and can still verify bcrypt hashes. main() replaces it with one built
+// from the command-line flags before serving any requests.
+var PasswordHasher = passhash.New(passhash.DefaultArgon2id(), passhash.Bcrypt{Cost: 12})
+
 type User struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -20,11 +27,15 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	Version   int       `json:"version"`
+	// Impersonator is the administrator acting as this user, when the request was
+	// authenticated with an impersonation token. It is nil otherwise.
+	Impersonator *User `json:"-"`
 }
 
 type UserModel struct {
 	db *sql.DB
+	queryTrace
 }
 
 // Define a custom ErrDuplicateEmail error.
@@ -46,41 +57,35 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-// The Set() method calculates the bcrypt hash of a plaintext password, and stores both
+// The Set() method hashes a plaintext password with the PasswordHasher, and stores both
 // the hash and the plaintext versions in the struct.
 func (p *password) Set(plainPass string) error {
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainPass), 12)
+	hash, err := PasswordHasher.Hash(plainPass)
 
 	if err != nil {
 		return err
 	}
 
 	p.plaintext = &plainPass
-	p.hash = hash
+	p.hash = []byte(hash)
 
 	return nil
 }
 
 // The Matches()  method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
-// otherwise
+// otherwise. A hash which can't be checked at all, for example because it's corrupt,
+// is reported as an error rather than as a mismatch.
 func (p *password) Matches(plainPass string) (bool, error) {
+	return PasswordHasher.Verify(plainPass, string(p.hash))
+}
 
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainPass))
-
-	// checked if it is matched!
-	// no?
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, nil
-		}
-	}
-	// yes?
-	return true, nil
+// NeedsRehash() reports whether the stored hash was made with an outdated algorithm or
+// parameters. It should be replaced by calling Set() with the plaintext, which is only
+// available when the user has just supplied it.
+func (p *password) NeedsRehash() bool {
+	return PasswordHasher.NeedsRehash(string(p.hash))
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -90,10 +95,17 @@ func ValidateEmail(v *validator.Validator, email string) {
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	ValidatePasswordField(v, "password", password)
+}
 
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+// ValidatePasswordField() checks a plaintext password like ValidatePasswordPlaintext(),
+// for a password sent in a field with another name, such as new_password, so that the
+// errors are reported against that field.
+func ValidatePasswordField(v *validator.Validator, key, password string) {
+
+	v.Check(password != "", key, "must be provided")
+	v.Check(len(password) >= 8, key, "must be at least 8 bytes long")
+	v.Check(len(password) <= 1024, key, "must not be more than 1024 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -146,7 +158,7 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("UserModel.GetForToken", 3*time.Second)
 	defer cancel()
 
 	err := m.db.QueryRowContext(ctx, query, args...).Scan(
@@ -171,11 +183,39 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 	return &user, nil
 }
 
-// Insert a new record in the database for the user. Note that the id, created_at and
-// version fields are all automatically generated by our database, so we use the
-// RETURNING clause to read them into the User struct after the insert, in the same way
-// that we did when creating a movie.
-func (m *UserModel) Insert(user *User) error {
+// Insert a new record in the database for the user, and give them the named roles, in
+// one transaction so that a user is never created without their roles. Note that the
+// id, created_at and version fields are all automatically generated by our database,
+// so we use the RETURNING clause to read them into the User struct after the insert,
+// in the same way that we did when creating a movie.
+func (m *UserModel) Insert(user *User, roles ...string) error {
+
+	ctx, cancel := m.startQuery("UserModel.Insert", 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	if len(roles) > 0 {
+		err = addRoles(ctx, tx, user.ID, roles)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertUser() inserts the user with q, and reads the generated fields back into it.
+func insertUser(ctx context.Context, q queryer, user *User) error {
 
 	query :=
 		`
@@ -186,14 +226,11 @@ func (m *UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.db.QueryRowContext(ctx, query, args...).
+	err := q.QueryRowContext(ctx, query, args...).
 		Scan(&user.ID, &user.CreatedAt, &user.Version)
 
 	if err != nil {
@@ -222,7 +259,7 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("UserModel.GetByEmail", 3*time.Second)
 	defer cancel()
 
 	err := m.db.QueryRowContext(ctx, query, email).Scan(
@@ -246,6 +283,47 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// Retrieve the User details from the database based on the user's ID.
+func (m *UserModel) Get(id int64) (*User, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query :=
+		`
+		SELECT id, created_at, name, email, password_hash, activated, version
+			FROM users
+		WHERE
+			id = $1
+		`
+
+	var user User
+
+	ctx, cancel := m.startQuery("UserModel.Get", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m *UserModel) UpdateUser(user *User) error {
 
 	query := `
@@ -256,7 +334,7 @@ func (m *UserModel) UpdateUser(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.startQuery("UserModel.UpdateUser", 3*time.Second)
 	defer cancel()
 
 	// communicate with the db and get the data
@@ -266,6 +344,10 @@ func (m *UserModel) UpdateUser(user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		// If no matching row could be found, we know the user version has changed
+		// (or the record has been deleted) since we read it.
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -273,3 +355,256 @@ func (m *UserModel) UpdateUser(user *User) error {
 
 	return nil
 }
+
+// GetAll() returns a page of users, optionally filtered by a case-insensitive substring
+// of their name or email and by their activation status. A nil activated value matches
+// both activated and inactive users.
+func (m *UserModel) GetAll(name, email string, activated *bool, f Filters) ([]*User, Metadata, error) {
+
+	query := fmt.Sprintf(
+		`
+		SELECT
+			count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE
+			(strpos(lower(name), lower($1)) > 0 OR $1 = '')
+		AND
+			(strpos(lower(email::text), lower($2)) > 0 OR $2 = '')
+		AND
+			($3::boolean IS NULL OR activated = $3)
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5
+		`, f.sortColumn(), f.sortDirection())
+
+	ctx, cancel := m.startQuery("UserModel.GetAll", 3*time.Second)
+	defer cancel()
+
+	args := []any{name, email, activated, f.limit(), f.offset()}
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+
+	return users, metadata, nil
+}
+
+// Delete() removes a user, and records it in the audit log in the same transaction.
+// Their tokens, keys and permission grants are removed along with them by the ON DELETE
+// CASCADE foreign keys.
+func (m *UserModel) Delete(id int64, audit *AuditEntry) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := m.startQuery("UserModel.Delete", 3*time.Second)
+	defer cancel()
+
+	return auditedTx(ctx, m.db, audit, func(tx *sql.Tx) error {
+		return deleteUser(ctx, tx, id)
+	})
+}
+
+// deleteUser() deletes the user with the given ID with q, and returns ErrRecordNotFound
+// if there was no such user.
+func deleteUser(ctx context.Context, q queryer, id int64) error {
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	result, err := q.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdateActivation() saves the user's activation status, and records the change in the
+// audit log in the same transaction. A user who is deactivated is also signed out
+// everywhere, by deleting their authentication tokens. Like UpdateUser(), it returns
+// ErrEditConflict if the user has changed since they were read.
+func (m *UserModel) UpdateActivation(user *User, audit *AuditEntry) error {
+
+	query := `
+		UPDATE users
+			SET activated = $1, version = version + 1
+			WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	ctx, cancel := m.startQuery("UserModel.UpdateActivation", 3*time.Second)
+	defer cancel()
+
+	return auditedTx(ctx, m.db, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, user.Activated, user.ID, user.Version).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		if user.Activated {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, ScopeAuthentication, user.ID)
+		return err
+	})
+}
+
+// GetForOAuthToken() returns the user that an OAuth access token acts for, along with
+// the token itself so that the caller knows which client holds it and what it may do.
+func (m *UserModel) GetForOAuthToken(tokenPlaintext string) (*User, *Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT
+			users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+			tokens.expiry, tokens.client_id, tokens.permissions
+		FROM users
+			INNER JOIN tokens
+			ON users.id = tokens.user_id
+			WHERE tokens.hash = $1
+			AND tokens.scope = $2
+			AND tokens.expiry > $3`
+
+	args := []any{tokenHash[:], ScopeOAuthAccess, time.Now()}
+
+	var user User
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+		Scope:     ScopeOAuthAccess,
+	}
+
+	ctx, cancel := m.startQuery("UserModel.GetForOAuthToken", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&token.Expiry,
+		&token.ClientID,
+		pq.Array((*[]string)(&token.Permissions)),
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	token.UserId = user.ID
+
+	return &user, &token, nil
+}
+
+// GetForAuthenticationToken() returns the user for a token which can be used to
+// authenticate requests: either an authentication token, or an impersonation token. The
+// token is returned too, so that the caller can tell which it is and, for an
+// impersonation token, who the impersonator is.
+func (m *UserModel) GetForAuthenticationToken(tokenPlaintext string) (*User, *Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT
+			users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+			tokens.expiry, tokens.scope, COALESCE(tokens.impersonator_id, 0)
+		FROM users
+			INNER JOIN tokens
+			ON users.id = tokens.user_id
+			WHERE tokens.hash = $1
+			AND tokens.scope = ANY($2)
+			AND tokens.expiry > $3`
+
+	scopes := []string{ScopeAuthentication, ScopeImpersonation}
+	args := []any{tokenHash[:], pq.Array(scopes), time.Now()}
+
+	var user User
+	token := Token{
+		Plaintext: tokenPlaintext,
+		Hash:      tokenHash[:],
+	}
+
+	ctx, cancel := m.startQuery("UserModel.GetForAuthenticationToken", 3*time.Second)
+	defer cancel()
+
+	err := m.db.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&token.Expiry,
+		&token.Scope,
+		&token.ImpersonatorID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	token.UserId = user.ID
+
+	return &user, &token, nil
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInsertWithRoles(t *testing.T) {
+	models := newTestModels(t)
+
+	user := &User{
+		Name:  "Test User",
+		Email: fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()),
+	}
+	user.Password.hash = []byte("not a real hash")
+
+	err := models.User.Insert(user, "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(roles, []string{"viewer"}) {
+		t.Errorf("roles = %q; want [viewer]", roles)
+	}
+
+	// A second signup with the same address is refused, rather than leaving a user
+	// without a role behind.
+	again := &User{Name: "Test User", Email: user.Email}
+	again.Password.hash = []byte("not a real hash")
+
+	err = models.User.Insert(again, "viewer")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v; want ErrDuplicateEmail", err)
+	}
+}
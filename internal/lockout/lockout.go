@@ -0,0 +1,144 @@
+// Package lockout tracks failed login attempts and decides when further attempts should
+// be slowed down or refused.
+//
+// Attempts are counted per key, where a key is whatever the caller wants to protect: an
+// account, a client IP address, and so on. After a number of failures each further
+// attempt has to wait an exponentially growing delay, and after more failures the key
+// is locked out completely for a fixed period.
+package lockout
+
+import (
+	"time"
+)
+
+// Entry holds the failed attempts recorded against a single key.
+type Entry struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store persists entries. The in-memory store returned by NewMemoryStore is enough for
+// a single instance of the API; running several instances needs a shared store so that
+// an attacker can't spread their attempts across them.
+//
+// RecordFailure must be atomic, because concurrent failures for the same key must all
+// be counted.
+type Store interface {
+	// Get returns the entry for a key, or the zero Entry if there isn't one.
+	Get(key string) (Entry, error)
+	// RecordFailure increments the failure count for a key, sets its last failure
+	// time to now and returns the updated entry. The entry should be forgotten once
+	// ttl has passed without another failure, and also once a lock on it has expired,
+	// so that the failure which follows a lockout starts counting from one again
+	// rather than locking the key again straight away.
+	RecordFailure(key string, now time.Time, ttl time.Duration) (Entry, error)
+	// Lock marks a key as locked until the given time.
+	Lock(key string, until time.Time) error
+	// Reset forgets everything recorded for a key.
+	Reset(key string) error
+}
+
+// Config controls when a Guard starts slowing down and locking out a key.
+type Config struct {
+	// BackoffAfter is the number of failures after which each further attempt must
+	// wait BackoffBase, doubling with every additional failure.
+	BackoffAfter int
+	BackoffBase  time.Duration
+	// LockoutAfter is the number of failures after which the key is locked out for
+	// LockoutDuration.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// Guard applies a Config to the keys held in a Store.
+type Guard struct {
+	store Store
+	cfg   Config
+	now   func() time.Time
+}
+
+// New returns a Guard which records failures in store.
+func New(store Store, cfg Config) *Guard {
+	return &Guard{
+		store: store,
+		cfg:   cfg,
+		now:   time.Now,
+	}
+}
+
+// Check returns how long the caller has to wait before another attempt for key is
+// allowed. A zero duration means that the attempt may go ahead.
+func (g *Guard) Check(key string) (time.Duration, error) {
+	entry, err := g.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	now := g.now()
+
+	if now.Before(entry.LockedUntil) {
+		return entry.LockedUntil.Sub(now), nil
+	}
+
+	if entry.Failures < g.cfg.BackoffAfter {
+		return 0, nil
+	}
+
+	next := entry.LastFailure.Add(g.backoff(entry.Failures))
+	if now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// Fail records a failed attempt for key. It returns true if this failure caused the key
+// to be locked out, so that the caller can notify the account owner exactly once per
+// lockout.
+func (g *Guard) Fail(key string) (bool, error) {
+	now := g.now()
+
+	entry, err := g.store.RecordFailure(key, now, g.ttl())
+	if err != nil {
+		return false, err
+	}
+
+	if entry.Failures < g.cfg.LockoutAfter || now.Before(entry.LockedUntil) {
+		return false, nil
+	}
+
+	err = g.store.Lock(key, now.Add(g.cfg.LockoutDuration))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Reset clears the failures recorded for key, for example after a successful login or
+// when an administrator unlocks an account.
+func (g *Guard) Reset(key string) error {
+	return g.store.Reset(key)
+}
+
+// backoff returns the delay required after the given number of failures. It doubles
+// with every failure past BackoffAfter, and never exceeds the lockout duration.
+func (g *Guard) backoff(failures int) time.Duration {
+	delay := g.cfg.BackoffBase
+	for i := g.cfg.BackoffAfter; i < failures; i++ {
+		delay *= 2
+		if delay >= g.cfg.LockoutDuration {
+			return g.cfg.LockoutDuration
+		}
+	}
+	return delay
+}
+
+// ttl returns how long an entry must be kept for. It is at least as long as the
+// lockout, so that a lock is never forgotten before it expires.
+func (g *Guard) ttl() time.Duration {
+	return max(g.cfg.Window, g.cfg.LockoutDuration)
+}
@@ -0,0 +1,187 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is shared by a Guard and its MemoryStore, so that tests control the time
+// that both of them see.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGuard(cfg Config) (*Guard, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	store := NewMemoryStore()
+	store.now = clock.now
+
+	g := New(store, cfg)
+	g.now = clock.now
+
+	return g, clock
+}
+
+var testConfig = Config{
+	BackoffAfter:    3,
+	BackoffBase:     time.Second,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// fail records n failures for key, and reports whether any of them locked it.
+func fail(t *testing.T, g *Guard, key string, n int) bool {
+	t.Helper()
+
+	locked := false
+	for i := 0; i < n; i++ {
+		l, err := g.Fail(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		locked = locked || l
+	}
+	return locked
+}
+
+func check(t *testing.T, g *Guard, key string) time.Duration {
+	t.Helper()
+
+	wait, err := g.Check(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestBackoff(t *testing.T) {
+	g, clock := newTestGuard(testConfig)
+
+	fail(t, g, "k", 2)
+	if wait := check(t, g, "k"); wait != 0 {
+		t.Fatalf("wait after 2 failures = %v; want 0", wait)
+	}
+
+	// From BackoffAfter failures on, the delay starts at BackoffBase and doubles.
+	for failures, want := range map[int]time.Duration{3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 8 * time.Second} {
+		g, clock := newTestGuard(testConfig)
+		fail(t, g, "k", failures)
+
+		if wait := check(t, g, "k"); wait != want {
+			t.Errorf("wait after %d failures = %v; want %v", failures, wait, want)
+		}
+
+		clock.advance(want)
+		if wait := check(t, g, "k"); wait != 0 {
+			t.Errorf("wait once the %v delay has passed = %v; want 0", want, wait)
+		}
+	}
+
+	// Failures are forgotten after Window.
+	fail(t, g, "k", 5)
+	clock.advance(testConfig.Window + time.Second)
+	fail(t, g, "k", 1)
+	if wait := check(t, g, "k"); wait != 0 {
+		t.Errorf("wait after the window passed = %v; want 0", wait)
+	}
+}
+
+func TestBackoffIsCappedAtLockoutDuration(t *testing.T) {
+	cfg := testConfig
+	cfg.LockoutAfter = 100
+
+	g, _ := newTestGuard(cfg)
+	fail(t, g, "k", 50)
+
+	if wait := check(t, g, "k"); wait != cfg.LockoutDuration {
+		t.Errorf("wait = %v; want %v", wait, cfg.LockoutDuration)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	g, clock := newTestGuard(testConfig)
+
+	if fail(t, g, "k", 9) {
+		t.Fatal("locked before LockoutAfter failures")
+	}
+
+	locked, err := g.Fail("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked {
+		t.Fatal("not locked after LockoutAfter failures")
+	}
+
+	if wait := check(t, g, "k"); wait != testConfig.LockoutDuration {
+		t.Errorf("wait while locked = %v; want %v", wait, testConfig.LockoutDuration)
+	}
+
+	// Failing again while locked doesn't report a new lockout, so only one email is
+	// sent.
+	clock.advance(time.Minute)
+	if fail(t, g, "k", 5) {
+		t.Error("failure while already locked reported a new lockout")
+	}
+	if wait := check(t, g, "k"); wait != testConfig.LockoutDuration-time.Minute {
+		t.Errorf("wait while locked = %v; want %v", wait, testConfig.LockoutDuration-time.Minute)
+	}
+
+	// Other keys are unaffected.
+	if wait := check(t, g, "other"); wait != 0 {
+		t.Errorf("wait for another key = %v; want 0", wait)
+	}
+}
+
+func TestFailureAfterLockExpiresStartsAgain(t *testing.T) {
+	g, clock := newTestGuard(testConfig)
+
+	if !fail(t, g, "k", 10) {
+		t.Fatal("not locked after LockoutAfter failures")
+	}
+
+	clock.advance(testConfig.LockoutDuration)
+	if wait := check(t, g, "k"); wait != 0 {
+		t.Fatalf("wait once the lock expired = %v; want 0", wait)
+	}
+
+	// The next failure counts as the first, rather than locking the key again.
+	if fail(t, g, "k", 1) {
+		t.Fatal("first failure after the lock expired locked the key again")
+	}
+	if wait := check(t, g, "k"); wait != 0 {
+		t.Errorf("wait after one new failure = %v; want 0", wait)
+	}
+
+	// It takes another LockoutAfter failures to lock it again, which reports a new
+	// lockout.
+	if fail(t, g, "k", 8) {
+		t.Fatal("locked again too soon")
+	}
+	if !fail(t, g, "k", 1) {
+		t.Error("not locked again after another LockoutAfter failures")
+	}
+}
+
+func TestReset(t *testing.T) {
+	g, _ := newTestGuard(testConfig)
+
+	fail(t, g, "k", 10)
+
+	err := g.Reset("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait := check(t, g, "k"); wait != 0 {
+		t.Errorf("wait after Reset = %v; want 0", wait)
+	}
+	if fail(t, g, "k", 9) {
+		t.Error("locked before LockoutAfter failures after Reset")
+	}
+}
@@ -0,0 +1,115 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// How often the memory store sweeps out expired entries.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	Entry
+	expiresAt time.Time
+}
+
+// MemoryStore is a Store which keeps entries in a map. Expired entries are removed
+// lazily as the store is written to, so it doesn't need a background goroutine.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || s.now().After(e.expiresAt) {
+		return Entry{}, nil
+	}
+
+	return e.Entry, nil
+}
+
+func (s *MemoryStore) RecordFailure(key string, now time.Time, ttl time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expiresAt) || lockExpired(e.Entry, now) {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+
+	e.Failures++
+	e.LastFailure = now
+	e.expiresAt = later(e.expiresAt, now.Add(ttl))
+
+	return e.Entry, nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+
+	e.LockedUntil = until
+	e.expiresAt = later(e.expiresAt, until)
+
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+// sweep removes expired entries, at most once per sweepInterval. The caller must hold
+// the mutex.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+
+	for key, e := range s.entries {
+		if now.After(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.lastSweep = now
+}
+
+// lockExpired reports whether the entry was locked, and the lock has run out by now.
+func lockExpired(e Entry, now time.Time) bool {
+	return !e.LockedUntil.IsZero() && !now.Before(e.LockedUntil)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+// Package logging builds the application's structured logger.
+//
+// Attributes can be attached to a context with With, and every record logged with that
+// context carries them. This is how each log line written while serving a request gets
+// the request ID, user ID, method and path, without every call having to pass them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+type contextKey struct{}
+
+// With returns a copy of ctx which carries attrs, in addition to any attributes that
+// ctx already carries.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return context.WithValue(ctx, contextKey{}, append(slices.Clip(existing), attrs...))
+}
+
+// ContextHandler adds the attributes carried by the context to every record, and
+// otherwise passes records on to the wrapped Handler.
+type ContextHandler struct {
+	slog.Handler
+}
+
+func (h ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ContextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h ContextHandler) WithGroup(name string) slog.Handler {
+	return ContextHandler{h.Handler.WithGroup(name)}
+}
+
+// New returns a logger which writes records at or above level to w, formatted as
+// "json" or "text".
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(ContextHandler{handler}), nil
+}
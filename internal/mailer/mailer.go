@@ -2,11 +2,14 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"github.com/ridwanulhoquejr/lets-go-further/internal/tracing"
 )
 
 // Below we declare a new variable with the type embed.FS (embedded file system) to hold
@@ -20,28 +23,65 @@ var templateFS embed.FS
 
 // Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
 // SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
+// want the email to be from, such as "Alice Smith <alice@example.com>"). They are kept
+// together behind an atomic pointer, so that Update() can replace them while emails are
+// being sent.
 type Mailer struct {
+	server atomic.Pointer[server]
+
+	// sent and failed count the emails which were sent, and the ones which still
+	// couldn't be sent after retrying.
+	sent   atomic.Int64
+	failed atomic.Int64
+}
+
+type server struct {
 	dialer *mail.Dialer
 	sender string
 }
 
 func New(host string, port int, username, password, sender string) *Mailer {
+	m := &Mailer{}
+	m.Update(host, port, username, password, sender)
+	return m
+}
+
+// Update switches to different SMTP server settings. Emails which are already being
+// sent carry on with the old settings.
+func (m *Mailer) Update(host string, port int, username, password, sender string) {
 	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
 	// also configure this to use a 5-second timeout whenever we send an email.
 	dialer := mail.NewDialer(host, port, username, password)
 	dialer.Timeout = 5 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
-	return &Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+
+	m.server.Store(&server{dialer: dialer, sender: sender})
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
 // dynamic data for the templates as an interface{} parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+//
+// Send also counts the emails which were sent and the ones which failed, and records a
+// span for the send as part of the trace in ctx. Emails are usually sent from a
+// background goroutine once the request which triggered them has finished, so ctx is
+// only used for tracing and cancelling it doesn't stop the send.
+func (m *Mailer) Send(ctx context.Context, recipient, templateFile string, data any) error {
+	_, span := tracing.Start(ctx, "mailer.Send")
+	span.SetAttr("mail.template", templateFile)
+	defer span.End()
+
+	err := m.send(recipient, templateFile, data)
+	if err != nil {
+		span.RecordError(err)
+		m.failed.Add(1)
+		return err
+	}
+
+	m.sent.Add(1)
+	return nil
+}
+
+func (m *Mailer) send(recipient, templateFile string, data any) error {
 	// Use the ParseFS() method to parse the required template file from the embedded
 	// file system.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
@@ -69,6 +109,9 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 		return err
 	}
 
+	// Use the same server settings for every attempt, even if they are updated.
+	srv := m.server.Load()
+
 	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
 	// Then we use the SetHeader() method to set the email recipient, sender and subject
 	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
@@ -76,7 +119,7 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	// always be called *after* SetBody().
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
+	msg.SetHeader("From", srv.sender)
 	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
@@ -89,7 +132,7 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	// Try sending the email up to three times before aborting and returning the final
 	// error. We sleep for 500 milliseconds between each attempt.
 	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
+		err = srv.dialer.DialAndSend(msg)
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
@@ -98,5 +141,40 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 		time.Sleep(500 * time.Millisecond)
 	}
 
-	return nil
+	return err
+}
+
+// Sent returns the number of emails which have been sent successfully.
+func (m *Mailer) Sent() int64 {
+	return m.sent.Load()
+}
+
+// Failed returns the number of emails which couldn't be sent, even after retrying.
+func (m *Mailer) Failed() int64 {
+	return m.failed.Load()
+}
+
+// Ping checks that the SMTP server can be reached and accepts the credentials, by
+// connecting and logging in without sending anything. It gives up when ctx is done,
+// although the connection attempt itself carries on in the background until the
+// dialer's timeout.
+func (m *Mailer) Ping(ctx context.Context) error {
+	srv := m.server.Load()
+
+	result := make(chan error, 1)
+	go func() {
+		conn, err := srv.dialer.Dial()
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- conn.Close()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
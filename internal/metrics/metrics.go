@@ -0,0 +1,284 @@
+// Package metrics collects counters, gauges and histograms and writes them out in the
+// Prometheus text exposition format, so that a Prometheus server can scrape them.
+//
+// Only what the API needs is implemented: labelled counters and histograms which the
+// application updates as it goes, and gauges and counters whose value is read from a
+// function at scrape time.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds. They suit the latency of
+// HTTP requests.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics which are written out together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the registry to w, in the order that they were created.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns a http.Handler which serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// desc is the name, help text and label names that a metric is written out with.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key() joins label values into a map key. The separator can't appear in valid UTF-8.
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs() formats label names and values as they appear between the braces, for
+// example `method="GET",status="200"`.
+func labelPairs(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	if labels == "" {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(value))
+}
+
+// CounterVec is a set of counters, one for each combination of label values.
+type CounterVec struct {
+	desc
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec creates and registers a CounterVec with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]*counterValue),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds 1 to the counter with the given label values, which must be in the same
+// order as the label names.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels but got %d values", c.name, len(c.labels), len(labelValues)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key(labelValues)
+	cv, ok := c.values[k]
+	if !ok {
+		cv = &counterValue{labels: slices.Clone(labelValues)}
+		c.values[k] = cv
+	}
+	cv.value += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, k := range sortedKeys(c.values) {
+		cv := c.values[k]
+		writeSample(w, c.name, labelPairs(c.labels, cv.labels), cv.value)
+	}
+}
+
+// HistogramVec is a set of histograms, one for each combination of label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // counts[i] is the number of observations <= buckets[i]
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a HistogramVec with the given upper bucket
+// bounds, which must be sorted, and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels but got %d values", h.name, len(h.labels), len(labelValues)))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := key(labelValues)
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogramValue{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, k := range sortedKeys(h.values) {
+		hv := h.values[k]
+		labels := labelPairs(h.labels, hv.labels)
+
+		prefix := labels
+		if prefix != "" {
+			prefix += ","
+		}
+
+		for i, upper := range h.buckets {
+			writeSample(w, h.name+"_bucket", prefix+`le="`+formatFloat(upper)+`"`, float64(hv.counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", prefix+`le="+Inf"`, float64(hv.count))
+		writeSample(w, h.name+"_sum", labels, hv.sum)
+		writeSample(w, h.name+"_count", labels, float64(hv.count))
+	}
+}
+
+// funcMetric is a metric without labels whose value is read from a function each time
+// that it is written out.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is fn().
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc creates and registers a counter whose value is fn(). The value must
+// never go down.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	writeSample(w, f.name, "", f.fn())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
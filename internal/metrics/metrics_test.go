@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounterVec("http_requests_total", "Total HTTP requests served.", "method", "route")
+	requests.Inc("GET", "/v1/movies")
+	requests.Inc("GET", "/v1/movies")
+	requests.Add(2.5, "POST", "/v1/movies")
+	// Backslashes, quotes and newlines are escaped in label values.
+	requests.Inc("GET", "a\\b \"c\"\nd")
+
+	duration := reg.NewHistogramVec("http_request_duration_seconds", "HTTP request latency\nin seconds.", []float64{0.1, 0.5, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		duration.Observe(v, "/v1/movies")
+	}
+
+	unlabelled := reg.NewHistogramVec("job_duration_seconds", "Job duration.", []float64{1})
+	unlabelled.Observe(0.5)
+
+	reg.NewGaugeFunc("in_flight", "Requests in flight.", func() float64 { return 3 })
+	reg.NewCounterFunc("evictions_total", "Evictions.", func() float64 { return 1e21 })
+
+	// Metrics appear in the order that they were created, and series are sorted by
+	// their label values. Histogram buckets are cumulative: each counts every
+	// observation up to its bound, and +Inf equals the count.
+	want := `# HELP http_requests_total Total HTTP requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/v1/movies"} 2
+http_requests_total{method="GET",route="a\\b \"c\"\nd"} 1
+http_requests_total{method="POST",route="/v1/movies"} 2.5
+# HELP http_request_duration_seconds HTTP request latency in seconds.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="/v1/movies",le="0.1"} 2
+http_request_duration_seconds_bucket{route="/v1/movies",le="0.5"} 3
+http_request_duration_seconds_bucket{route="/v1/movies",le="1"} 4
+http_request_duration_seconds_bucket{route="/v1/movies",le="+Inf"} 5
+http_request_duration_seconds_sum{route="/v1/movies"} 3.15
+http_request_duration_seconds_count{route="/v1/movies"} 5
+# HELP job_duration_seconds Job duration.
+# TYPE job_duration_seconds histogram
+job_duration_seconds_bucket{le="1"} 1
+job_duration_seconds_bucket{le="+Inf"} 1
+job_duration_seconds_sum 0.5
+job_duration_seconds_count 1
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 3
+# HELP evictions_total Evictions.
+# TYPE evictions_total counter
+evictions_total 1e+21
+`
+
+	var got strings.Builder
+	err := reg.Write(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got.String(), want)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounterVec("requests_total", "Requests.", "method", "route")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with too few label values didn't panic")
+		}
+	}()
+
+	requests.Inc("GET")
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewGaugeFunc("up", "Whether the API is up.", func() float64 { return 1 })
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body, _ := io.ReadAll(rr.Body)
+	if !strings.HasSuffix(string(body), "\nup 1\n") {
+		t.Errorf("body = %q; want it to end with the sample", body)
+	}
+}
@@ -0,0 +1,83 @@
+package passhash
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only uses the first 72 bytes of a password. Longer passwords are first reduced
+// with SHA-256, and their hashes are marked with this prefix so that Verify knows to do
+// the same. Hashes of shorter passwords are plain bcrypt hashes, which keeps them
+// compatible with hashes made before this package existed.
+const bcryptSHA256Prefix = "$bcrypt-sha256"
+
+const bcryptMaxLength = 72
+
+// Bcrypt hashes passwords with bcrypt.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) ID() string {
+	return "bcrypt"
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	prefix := ""
+	if len(password) > bcryptMaxLength {
+		password = prehash(password)
+		prefix = bcryptSHA256Prefix
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + string(hash), nil
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, bcryptSHA256Prefix):
+		encoded = strings.TrimPrefix(encoded, bcryptSHA256Prefix)
+		password = prehash(password)
+	// A plain bcrypt hash is always of a password of at most 72 bytes. bcrypt would
+	// ignore the rest of a longer password, so check the length here rather than let
+	// it match on its first 72 bytes.
+	case len(password) > bcryptMaxLength:
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(strings.TrimPrefix(encoded, bcryptSHA256Prefix)))
+	if err != nil {
+		return true
+	}
+
+	return cost != b.Cost
+}
+
+// prehash() reduces a password to 44 bytes. The digest is base64 encoded because bcrypt
+// stops at the first NUL byte.
+func prehash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,112 @@
+// Package passhash hashes and verifies passwords. Hashes are stored in PHC string
+// format, such as "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>", which records the
+// algorithm and its parameters alongside the hash. That lets hashes made by different
+// algorithms, or with different parameters, coexist in the same column: each one is
+// verified with the parameters it was made with, and NeedsRehash reports the ones that
+// should be upgraded the next time the plaintext is available.
+package passhash
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidHash is returned when a stored hash can't be parsed.
+	ErrInvalidHash = errors.New("passhash: invalid hash format")
+	// ErrUnknownAlgorithm is returned when a stored hash was made by an algorithm that
+	// the Manager hasn't been given a Hasher for.
+	ErrUnknownAlgorithm = errors.New("passhash: unknown hash algorithm")
+)
+
+// A Hasher implements one password hashing algorithm.
+type Hasher interface {
+	// ID returns the PHC identifier of the algorithm, such as "argon2id".
+	ID() string
+	// Hash returns the encoded hash of a password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches an encoded hash made by this
+	// algorithm. A mismatch is not an error.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether an encoded hash made by this algorithm used
+	// different parameters from the Hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// A Manager hashes new passwords with its current Hasher, and verifies existing hashes
+// with whichever of its Hashers made them.
+type Manager struct {
+	current Hasher
+	hashers map[string]Hasher
+}
+
+// New returns a Manager which hashes with current and can also verify hashes made by
+// any of the others.
+func New(current Hasher, others ...Hasher) *Manager {
+	m := &Manager{
+		current: current,
+		hashers: map[string]Hasher{current.ID(): current},
+	}
+
+	for _, h := range others {
+		if _, ok := m.hashers[h.ID()]; !ok {
+			m.hashers[h.ID()] = h
+		}
+	}
+
+	return m
+}
+
+// Hash returns the encoded hash of a password, made with the current Hasher.
+func (m *Manager) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Verify reports whether the password matches an encoded hash.
+func (m *Manager) Verify(password, encoded string) (bool, error) {
+	h, err := m.hasherFor(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	return h.Verify(password, encoded)
+}
+
+// NeedsRehash reports whether an encoded hash should be replaced, because it was made
+// by a different algorithm or with different parameters from the current ones.
+func (m *Manager) NeedsRehash(encoded string) bool {
+	if identify(encoded) != m.current.ID() {
+		return true
+	}
+
+	return m.current.NeedsRehash(encoded)
+}
+
+func (m *Manager) hasherFor(encoded string) (Hasher, error) {
+	id := identify(encoded)
+	if id == "" {
+		return nil, ErrInvalidHash
+	}
+
+	h, ok := m.hashers[id]
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+
+	return h, nil
+}
+
+// identify() returns the algorithm identifier of an encoded hash. bcrypt predates the
+// PHC format and uses its own "$2a$" style prefixes, so those are mapped to "bcrypt".
+func identify(encoded string) string {
+	if strings.HasPrefix(encoded, "$2") || strings.HasPrefix(encoded, bcryptSHA256Prefix) {
+		return "bcrypt"
+	}
+
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 2 || fields[0] != "" {
+		return ""
+	}
+
+	return fields[1]
+}
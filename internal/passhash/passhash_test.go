@@ -0,0 +1,263 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters, so that the tests run quickly.
+var (
+	testArgon2id = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = Bcrypt{Cost: bcrypt.MinCost}
+)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	encoded, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("hash %q isn't in PHC format with the configured parameters", encoded)
+	}
+
+	ok, err := testArgon2id.Verify("pa55word", encoded)
+	if err != nil || !ok {
+		t.Errorf("Verify(correct password) = %t, %v; want true, nil", ok, err)
+	}
+
+	ok, err = testArgon2id.Verify("pa55wore", encoded)
+	if err != nil || ok {
+		t.Errorf("Verify(wrong password) = %t, %v; want false, nil", ok, err)
+	}
+
+	// Every hash has its own salt.
+	again, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encoded {
+		t.Error("two hashes of the same password are identical")
+	}
+}
+
+func TestArgon2idVerifiesWithStoredParameters(t *testing.T) {
+	encoded, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hasher configured differently still verifies the hash with the parameters
+	// recorded in it.
+	other := testArgon2id
+	other.Memory = 128
+	other.Iterations = 2
+	other.KeyLength = 16
+
+	ok, err := other.Verify("pa55word", encoded)
+	if err != nil || !ok {
+		t.Errorf("Verify with other parameters = %t, %v; want true, nil", ok, err)
+	}
+}
+
+func TestDecodeArgon2id(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		valid   bool
+	}{
+		{"valid", "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$aGFzaGhhc2hoYXNo", true},
+		{"other algorithm", "$argon2i$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$aGFzaGhhc2hoYXNo", false},
+		{"other version", "$argon2id$v=16$m=65536,t=3,p=2$c29tZXNhbHQ$aGFzaGhhc2hoYXNo", false},
+		{"missing field", "$argon2id$v=19$m=65536,t=3,p=2$aGFzaGhhc2hoYXNo", false},
+		{"extra field", "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$aGFzaGhhc2hoYXNo$", false},
+		{"bad parameters", "$argon2id$v=19$m=lots,t=3,p=2$c29tZXNhbHQ$aGFzaGhhc2hoYXNo", false},
+		{"bad salt", "$argon2id$v=19$m=65536,t=3,p=2$c29t!XNhbHQ$aGFzaGhhc2hoYXNo", false},
+		{"bad key", "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$aGFz!Ghhc2hoYXNo", false},
+		{"empty key", "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, salt, key, err := decodeArgon2id(tt.encoded)
+
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidHash) {
+					t.Errorf("err = %v; want ErrInvalidHash", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Argon2id{Memory: 65536, Iterations: 3, Parallelism: 2, SaltLength: 8, KeyLength: 12}
+			if params != want {
+				t.Errorf("params = %+v; want %+v", params, want)
+			}
+			if string(salt) != "somesalt" || string(key) != "hashhashhash" {
+				t.Errorf("salt, key = %q, %q; want \"somesalt\", \"hashhashhash\"", salt, key)
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	encoded, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testArgon2id.NeedsRehash(encoded) {
+		t.Error("hash made with the current parameters needs a rehash")
+	}
+
+	changes := map[string]func(*Argon2id){
+		"memory":      func(a *Argon2id) { a.Memory *= 2 },
+		"iterations":  func(a *Argon2id) { a.Iterations++ },
+		"parallelism": func(a *Argon2id) { a.Parallelism++ },
+		"salt length": func(a *Argon2id) { a.SaltLength = 32 },
+		"key length":  func(a *Argon2id) { a.KeyLength = 64 },
+	}
+	for name, change := range changes {
+		current := testArgon2id
+		change(&current)
+
+		if !current.NeedsRehash(encoded) {
+			t.Errorf("hash doesn't need a rehash after changing the %s", name)
+		}
+	}
+
+	if !testArgon2id.NeedsRehash("not a hash") {
+		t.Error("invalid hash doesn't need a rehash")
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	encoded, err := testBcrypt.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := testBcrypt.Verify("pa55word", encoded)
+	if err != nil || !ok {
+		t.Errorf("Verify(correct password) = %t, %v; want true, nil", ok, err)
+	}
+
+	ok, err = testBcrypt.Verify("pa55wore", encoded)
+	if err != nil || ok {
+		t.Errorf("Verify(wrong password) = %t, %v; want false, nil", ok, err)
+	}
+
+	if testBcrypt.NeedsRehash(encoded) {
+		t.Error("hash made with the current cost needs a rehash")
+	}
+	if !(Bcrypt{Cost: bcrypt.MinCost + 1}).NeedsRehash(encoded) {
+		t.Error("hash doesn't need a rehash after changing the cost")
+	}
+}
+
+func TestBcryptLongPasswords(t *testing.T) {
+	long := strings.Repeat("a", 100)
+
+	encoded, err := testBcrypt.Hash(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, bcryptSHA256Prefix) {
+		t.Errorf("hash of a long password %q isn't marked as prehashed", encoded)
+	}
+
+	ok, err := testBcrypt.Verify(long, encoded)
+	if err != nil || !ok {
+		t.Errorf("Verify(long password) = %t, %v; want true, nil", ok, err)
+	}
+
+	// bcrypt alone would only compare the first 72 bytes.
+	ok, err = testBcrypt.Verify(strings.Repeat("a", 99)+"b", encoded)
+	if err != nil || ok {
+		t.Errorf("Verify(password differing after 72 bytes) = %t, %v; want false, nil", ok, err)
+	}
+
+	// A plain bcrypt hash of the first 72 bytes doesn't match the whole password.
+	plain, err := bcrypt.GenerateFromPassword([]byte(long[:72]), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = testBcrypt.Verify(long, string(plain))
+	if err != nil || ok {
+		t.Errorf("Verify(long password, plain hash of its prefix) = %t, %v; want false, nil", ok, err)
+	}
+}
+
+func TestManagerMigratesBetweenAlgorithms(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  Hasher
+		wantNewID string
+	}{
+		{"bcrypt to argon2id", testBcrypt, testArgon2id, "$argon2id$"},
+		{"argon2id to bcrypt", testArgon2id, testBcrypt, "$2a$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := New(tt.from).Hash("pa55word")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// After switching algorithms, the old hash still verifies but should be
+			// replaced.
+			m := New(tt.to, tt.from)
+
+			ok, err := m.Verify("pa55word", old)
+			if err != nil || !ok {
+				t.Fatalf("Verify(old hash) = %t, %v; want true, nil", ok, err)
+			}
+			if !m.NeedsRehash(old) {
+				t.Error("old hash doesn't need a rehash")
+			}
+
+			rehashed, err := m.Hash("pa55word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(rehashed, tt.wantNewID) {
+				t.Errorf("rehashed %q doesn't start with %q", rehashed, tt.wantNewID)
+			}
+			if m.NeedsRehash(rehashed) {
+				t.Error("rehashed password needs another rehash")
+			}
+
+			ok, err = m.Verify("pa55word", rehashed)
+			if err != nil || !ok {
+				t.Errorf("Verify(rehashed) = %t, %v; want true, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestManagerRejectsUnknownHashes(t *testing.T) {
+	m := New(testArgon2id)
+
+	bcryptHash, err := testBcrypt.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Verify("pa55word", bcryptHash)
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Verify(hash by a missing hasher) err = %v; want ErrUnknownAlgorithm", err)
+	}
+
+	_, err = m.Verify("pa55word", "not a hash")
+	if !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("Verify(garbage) err = %v; want ErrInvalidHash", err)
+	}
+}
@@ -0,0 +1,154 @@
+package passpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+// CommonList returns a List of widely used passwords which is built into the binary.
+// Passwords are compared without regard to case.
+func CommonList() List {
+	list := &hashList{}
+
+	for _, line := range strings.Split(commonPasswords, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.hashes = append(list.hashes, sha1.Sum([]byte(strings.ToLower(line))))
+	}
+
+	slices.SortFunc(list.hashes, func(a, b [sha1.Size]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	return list
+}
+
+// hashList holds the SHA-1 hashes of its passwords in sorted order, so that lookups are
+// a binary search.
+type hashList struct {
+	hashes [][sha1.Size]byte
+}
+
+func (l *hashList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(strings.ToLower(password)))
+
+	_, found := slices.BinarySearchFunc(l.hashes, sum, func(a, b [sha1.Size]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	return found, nil
+}
+
+// FileList is a List backed by a file of SHA-1 password hashes, in the format of the
+// "ordered by hash" downloads from Have I Been Pwned: one upper-case hex hash per line,
+// optionally followed by a colon and a count, sorted by hash. The file is searched in
+// place with a binary search over byte offsets, so even the full list of several
+// hundred million hashes needs only a few dozen reads per lookup and no memory.
+//
+// Unlike CommonList, passwords are compared exactly, because that's how the hashes in
+// breach lists are made.
+type FileList struct {
+	f    *os.File
+	size int64
+}
+
+// OpenFileList opens a sorted hash file. The caller should Close it when finished.
+func OpenFileList(path string) (*FileList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FileList{f: f, size: info.Size()}, nil
+}
+
+func (l *FileList) Close() error {
+	return l.f.Close()
+}
+
+func (l *FileList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Find the smallest offset whose next line has a hash which isn't less than the
+	// target. Each probe reads the first whole line starting at or after an offset, so
+	// the search is over offsets rather than line numbers.
+	lo, hi := int64(0), l.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		hash, err := l.hashAfter(mid)
+		if err != nil {
+			return false, err
+		}
+
+		if hash == "" || hash >= target {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	hash, err := l.hashAfter(lo)
+	if err != nil {
+		return false, err
+	}
+
+	return hash == target, nil
+}
+
+// hashAfter() returns the hash on the first line which starts at or after offset, or ""
+// if there is no such line.
+func (l *FileList) hashAfter(offset int64) (string, error) {
+	if offset == 0 {
+		return l.lineAt(0)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(l.f, offset-1, l.size-offset+1))
+
+	// Skip the rest of the line containing offset-1. If offset-1 is itself a newline
+	// this skips just that byte, leaving us at the start of the line at offset.
+	_, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return readHash(r)
+}
+
+func (l *FileList) lineAt(offset int64) (string, error) {
+	return readHash(bufio.NewReader(io.NewSectionReader(l.f, offset, l.size-offset)))
+}
+
+// readHash() reads a line and returns the hash at its start, without any count.
+func readHash(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+
+	return strings.ToUpper(hash), nil
+}
@@ -0,0 +1,227 @@
+package passpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// hashed is a password and the upper-case hex SHA-1 hash it is listed under.
+type hashed struct {
+	password string
+	hash     string
+}
+
+// sortedByHash() returns the passwords in the order their hashes appear in a hash file.
+func sortedByHash(passwords []string) []hashed {
+	list := make([]hashed, len(passwords))
+	for i, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		list[i] = hashed{p, strings.ToUpper(hex.EncodeToString(sum[:]))}
+	}
+	slices.SortFunc(list, func(a, b hashed) int { return strings.Compare(a.hash, b.hash) })
+	return list
+}
+
+func writeHashFile(t *testing.T, content string) *FileList {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hashes.txt")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := OpenFileList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Close() })
+
+	return list
+}
+
+func TestFileList(t *testing.T) {
+	var candidates []string
+	for i := 0; i < 12; i++ {
+		candidates = append(candidates, fmt.Sprintf("password-%d", i))
+	}
+	all := sortedByHash(candidates)
+
+	// The file leaves out the candidates with the lowest and highest hashes and one in
+	// the middle, so that lookups miss before the first line, after the last and
+	// between two lines.
+	absent := []hashed{all[0], all[6], all[len(all)-1]}
+	listed := slices.Concat(all[1:6], all[7:len(all)-1])
+
+	formats := []struct {
+		name string
+		line func(h hashed, i int) string
+		// noFinalNewline drops the newline after the last line.
+		noFinalNewline bool
+	}{
+		{"with counts", func(h hashed, i int) string { return fmt.Sprintf("%s:%d\n", h.hash, i+1) }, false},
+		{"without counts", func(h hashed, i int) string { return h.hash + "\n" }, false},
+		{"no trailing newline", func(h hashed, i int) string { return h.hash + ":1\n" }, true},
+		{"CRLF", func(h hashed, i int) string { return fmt.Sprintf("%s:%d\r\n", h.hash, i+1) }, false},
+		{"lower case", func(h hashed, i int) string { return strings.ToLower(h.hash) + ":1\n" }, false},
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			var b strings.Builder
+			for i, h := range listed {
+				b.WriteString(format.line(h, i))
+			}
+			content := b.String()
+			if format.noFinalNewline {
+				content = strings.TrimSuffix(content, "\n")
+			}
+
+			list := writeHashFile(t, content)
+
+			// Every listed password is found, including those on the first and last
+			// lines.
+			for i, h := range listed {
+				found, err := list.Contains(h.password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !found {
+					t.Errorf("password on line %d of %d wasn't found", i+1, len(listed))
+				}
+			}
+
+			for _, h := range absent {
+				found, err := list.Contains(h.password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found {
+					t.Errorf("unlisted password %q was found", h.password)
+				}
+			}
+		})
+	}
+}
+
+func TestFileListEdgeCases(t *testing.T) {
+	one := sortedByHash([]string{"only-password"})[0]
+
+	tests := []struct {
+		name     string
+		content  string
+		password string
+		want     bool
+	}{
+		{"empty file", "", "only-password", false},
+		{"single line", one.hash + ":3\n", "only-password", true},
+		{"single line without newline", one.hash, "only-password", true},
+		{"single line, other password", one.hash + ":3\n", "another-password", false},
+		// Hashes in breach lists are of the exact password.
+		{"case matters", one.hash + ":3\n", "ONLY-PASSWORD", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := writeHashFile(t, tt.content)
+
+			found, err := list.Contains(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.want {
+				t.Errorf("Contains(%q) = %t; want %t", tt.password, found, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommonList(t *testing.T) {
+	list := CommonList()
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"PASSWORD", true},
+		{"PassWord", true},
+		{"123456789", true},
+		{"password1x", false},
+		// The comment lines at the top of the file aren't passwords.
+		{"# Common passwords, one per line, in lower case. Only passwords long enough to pass", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		found, err := list.Contains(tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != tt.want {
+			t.Errorf("Contains(%q) = %t; want %t", tt.password, found, tt.want)
+		}
+	}
+}
+
+func TestContainsPersonal(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		userName string
+		want     bool
+	}{
+		{"unrelated", "correct horse battery", "alice@example.com", "Alice Smith", false},
+		{"email address", "xalice@example.comx", "alice@example.com", "Alice Smith", true},
+		{"local part", "my-alice-password", "alice@example.com", "Bob", true},
+		{"full name", "alicesmith", "a@example.com", "alicesmith", true},
+		{"word of the name", "smith-and-wesson", "a@example.com", "Alice Smith", true},
+		{"ignores case", "SMITHSONIAN", "a@example.com", "alice smith", true},
+		{"short parts are ignored", "alpha-beta-gamma", "al@example.com", "Al Ba", false},
+		{"domain alone doesn't count", "example-password", "alice@example.com", "Alice Smith", false},
+		{"no name or email", "anything at all", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := containsPersonal(tt.password, tt.email, tt.userName)
+			if got != tt.want {
+				t.Errorf("containsPersonal(%q, %q, %q) = %t; want %t", tt.password, tt.email, tt.userName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	policy := &Policy{Lists: []List{CommonList()}, RejectPersonal: true}
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"a very unusual passphrase", ""},
+		{"Password", "is too common or has appeared in a data breach"},
+		{"alice-loves-cake", "must not contain your name or email address"},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+
+		err := policy.Check(v, "new_password", tt.password, "alice@example.com", "Alice Smith")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := v.Errors["new_password"]; got != tt.want {
+			t.Errorf("Check(%q) error = %q; want %q", tt.password, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package passpolicy decides whether a password is acceptable, beyond the length checks
+// in data.ValidatePasswordPlaintext(). It rejects passwords which appear in a list of
+// common or breached passwords, and passwords which contain the user's own name or
+// email address.
+package passpolicy
+
+import (
+	"strings"
+
+	"github.com/ridwanulhoquejr/lets-go-further/internal/validator"
+)
+
+// A List is a set of passwords which must not be used.
+type List interface {
+	Contains(password string) (bool, error)
+}
+
+// A Policy holds the checks to apply to new passwords.
+type Policy struct {
+	// Lists are checked in order. A password found in any of them is rejected.
+	Lists []List
+	// RejectPersonal rejects passwords which contain the user's name or email address.
+	RejectPersonal bool
+}
+
+// minPersonalLength is the length below which parts of a name or email address are
+// ignored. Otherwise a user called "Al" couldn't use any password containing "al".
+const minPersonalLength = 3
+
+// Check applies the policy to a password, recording any violation against key in the
+// validator. The email and name are those of the user the password is for. The returned
+// error is only for failures to read a list, not for policy violations.
+func (p *Policy) Check(v *validator.Validator, key, password, email, name string) error {
+
+	if p.RejectPersonal {
+		v.Check(!containsPersonal(password, email, name), key, "must not contain your name or email address")
+	}
+
+	for _, list := range p.Lists {
+		found, err := list.Contains(password)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			v.AddError(key, "is too common or has appeared in a data breach")
+			break
+		}
+	}
+
+	return nil
+}
+
+// containsPersonal() reports whether the password contains the email address, its
+// local part, the full name or any word of the name, ignoring case.
+func containsPersonal(password, email, name string) bool {
+	password = strings.ToLower(password)
+
+	parts := []string{email, name}
+	if local, _, ok := strings.Cut(email, "@"); ok {
+		parts = append(parts, local)
+	}
+	parts = append(parts, strings.Fields(name)...)
+
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) >= minPersonalLength && strings.Contains(password, part) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+// Package policy decides whether a user may act on a particular resource, as opposed to
+// on a kind of resource in general.
+//
+// A Rule pairs a permission code which applies to every resource of a kind, such as
+// "movie:write", with one that only applies to the resources a user owns, such as
+// "movie:write:own". The same Rule type works for any resource that reports its owner
+// through the Owned interface.
+package policy
+
+// Owned is implemented by resources which record the user who owns them. OwnerID
+// returns 0 if the owner isn't known, in which case only the global permission grants
+// access.
+type Owned interface {
+	OwnerID() int64
+}
+
+// Permissions is the set of permission codes held by the user who is acting.
+// data.Permissions satisfies it.
+type Permissions interface {
+	Include(code string) bool
+}
+
+// Rule describes the permission codes which allow an action on one kind of resource.
+type Rule struct {
+	// Any allows the action on every resource.
+	Any string
+	// Own allows the action only on resources owned by the acting user.
+	Own string
+}
+
+// Codes returns the permission codes which could allow the action on at least some
+// resources, for use in a route-level check before the resource has been loaded.
+func (r Rule) Codes() []string {
+	return []string{r.Any, r.Own}
+}
+
+// Allows reports whether the user with the given ID and permissions may act on the
+// resource.
+func (r Rule) Allows(userID int64, permissions Permissions, resource Owned) bool {
+	if permissions.Include(r.Any) {
+		return true
+	}
+
+	owner := resource.OwnerID()
+
+	return permissions.Include(r.Own) && owner != 0 && owner == userID
+}
@@ -0,0 +1,122 @@
+// Package ratelimit implements token bucket rate limiting for many clients at once.
+//
+// Each key, such as a client IP address or a user ID, gets its own bucket. A bucket
+// holds up to Burst tokens and refills at Rate tokens per second, and every request
+// takes one token. A request which finds the bucket empty is refused.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: Burst requests can be made at once, and after that
+// Rate requests per second on average.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result describes the state of a bucket after a request.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket size and Remaining is how many more requests could be made
+	// right now.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is zero if
+	// Allowed is true.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// refill() adds the tokens earned since the bucket was last used.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+	b.last = now
+}
+
+func (b *bucket) full() bool {
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// Limiter holds a bucket for every key that has been seen recently. It is safe for
+// concurrent use.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// New returns a Limiter without any buckets.
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket for key, creating a full bucket with the given
+// limit if there isn't one. If the limit for a key changes, its bucket keeps its
+// tokens but follows the new limit from then on.
+func (l *Limiter) Allow(key string, limit Limit) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.refill(now)
+
+	res := Result{Limit: limit.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Rate)
+
+	return res
+}
+
+// Cleanup removes the buckets which have filled up again. A full bucket behaves exactly
+// like a new one, so nothing is lost, and keys which have gone quiet stop taking up
+// memory. It returns the number of buckets that remain.
+func (l *Limiter) Cleanup() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.full() {
+			delete(l.buckets, key)
+		}
+	}
+
+	return len(l.buckets)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLimiter() returns a Limiter whose clock only moves when the test advances it.
+func newTestLimiter() (*Limiter, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	l := New()
+	l.now = func() time.Time { return now }
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+var testLimit = Limit{Rate: 2, Burst: 4}
+
+func TestBurst(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	for i := 1; i <= testLimit.Burst; i++ {
+		res := l.Allow("k", testLimit)
+		if !res.Allowed {
+			t.Fatalf("request %d of the burst was refused", i)
+		}
+		if res.Limit != testLimit.Burst || res.Remaining != testLimit.Burst-i {
+			t.Errorf("request %d: limit %d, remaining %d; want %d, %d", i, res.Limit, res.Remaining, testLimit.Burst, testLimit.Burst-i)
+		}
+	}
+
+	res := l.Allow("k", testLimit)
+	if res.Allowed {
+		t.Fatal("request after the burst was allowed")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("remaining = %d; want 0", res.Remaining)
+	}
+
+	// Other keys have their own buckets.
+	if !l.Allow("other", testLimit).Allowed {
+		t.Error("another key was refused")
+	}
+}
+
+func TestRetryAfterAndReset(t *testing.T) {
+	l, advance := newTestLimiter()
+
+	for i := 0; i < testLimit.Burst; i++ {
+		l.Allow("k", testLimit)
+	}
+
+	// At 2 tokens a second, the next token is half a second away and the bucket is
+	// full again in two seconds.
+	res := l.Allow("k", testLimit)
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("retry after = %v; want 500ms", res.RetryAfter)
+	}
+	if res.Reset != 2*time.Second {
+		t.Errorf("reset = %v; want 2s", res.Reset)
+	}
+
+	advance(250 * time.Millisecond)
+	res = l.Allow("k", testLimit)
+	if res.Allowed {
+		t.Fatal("request allowed before RetryAfter had passed")
+	}
+	if res.RetryAfter != 250*time.Millisecond {
+		t.Errorf("retry after = %v; want 250ms", res.RetryAfter)
+	}
+
+	advance(250 * time.Millisecond)
+	res = l.Allow("k", testLimit)
+	if !res.Allowed {
+		t.Fatal("request refused once RetryAfter had passed")
+	}
+	if res.RetryAfter != 0 {
+		t.Errorf("retry after for an allowed request = %v; want 0", res.RetryAfter)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	l, advance := newTestLimiter()
+
+	for i := 0; i < testLimit.Burst; i++ {
+		l.Allow("k", testLimit)
+	}
+
+	// One second earns two tokens.
+	advance(time.Second)
+	for i := 0; i < 2; i++ {
+		if !l.Allow("k", testLimit).Allowed {
+			t.Fatalf("request %d after refilling for 1s was refused", i+1)
+		}
+	}
+	if l.Allow("k", testLimit).Allowed {
+		t.Fatal("third request after refilling for 1s was allowed")
+	}
+
+	// The bucket never holds more than Burst tokens, however long it is left.
+	advance(time.Hour)
+	for i := 0; i < testLimit.Burst; i++ {
+		l.Allow("k", testLimit)
+	}
+	if l.Allow("k", testLimit).Allowed {
+		t.Error("bucket refilled beyond its burst")
+	}
+}
+
+func TestLimitChange(t *testing.T) {
+	l, _ := newTestLimiter()
+
+	l.Allow("k", testLimit)
+
+	// The bucket keeps its 3 tokens, and reports the new burst.
+	res := l.Allow("k", Limit{Rate: 1, Burst: 10})
+	if !res.Allowed || res.Limit != 10 || res.Remaining != 2 {
+		t.Errorf("got allowed %t, limit %d, remaining %d; want true, 10, 2", res.Allowed, res.Limit, res.Remaining)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	l, advance := newTestLimiter()
+
+	l.Allow("a", testLimit)
+	for i := 0; i < testLimit.Burst; i++ {
+		l.Allow("b", testLimit)
+	}
+
+	// a is full again after half a second, but b needs two seconds.
+	advance(500 * time.Millisecond)
+	if n := l.Cleanup(); n != 1 {
+		t.Fatalf("cleanup left %d buckets; want 1", n)
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("cleanup removed a bucket which isn't full")
+	}
+
+	advance(1500 * time.Millisecond)
+	if n := l.Cleanup(); n != 0 {
+		t.Fatalf("cleanup left %d buckets; want 0", n)
+	}
+
+	// A removed bucket starts full again.
+	for i := 0; i < testLimit.Burst; i++ {
+		if !l.Allow("b", testLimit).Allowed {
+			t.Fatalf("request %d after cleanup was refused", i+1)
+		}
+	}
+}
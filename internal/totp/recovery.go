@@ -0,0 +1,44 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+)
+
+// GenerateRecoveryCodes returns n random single-use recovery codes in the form
+// XXXX-XXXX-XXXX-XXXX, along with their hashes. Only the hashes should be stored.
+func GenerateRecoveryCodes(n int) ([]string, [][]byte, error) {
+	codes := make([]string, 0, n)
+	hashes := make([][]byte, 0, n)
+
+	for i := 0; i < n; i++ {
+		randomBytes := make([]byte, 10)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		raw := base32.StdEncoding.EncodeToString(randomBytes)
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the SHA-256 hash of a recovery code. The code is normalized
+// first, so that users don't have to type it exactly as it was displayed: case, dashes
+// and spaces are ignored.
+func HashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
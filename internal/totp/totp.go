@@ -0,0 +1,135 @@
+// Package totp implements time-based one-time passwords as described in RFC 6238,
+// using the defaults that authenticator apps expect: HMAC-SHA1, 6 digits and a 30
+// second period.
+//
+// None of the functions in this package read the system clock. Callers pass in the
+// time to generate or validate a code for, which keeps the package easy to test with a
+// fake clock.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Digits is the number of digits in a generated code.
+	Digits = 6
+	// Period is how long each code is valid for.
+	Period = 30 * time.Second
+	// Skew is the number of periods either side of the current one which are still
+	// accepted, to allow for clock drift between the server and the user's device.
+	Skew = 1
+	// SecretSize is the size of a generated secret in bytes, as recommended by
+	// RFC 4226 for HMAC-SHA1.
+	SecretSize = 20
+
+	// modulus is 10^Digits, and reduces the truncated HMAC to a code.
+	modulus = 1_000_000
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random shared secret.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns the secret in the unpadded base-32 form that users type into
+// their authenticator app when they can't scan a QR code.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// ProvisioningURI returns an otpauth:// URI for the secret. Rendering this URI as a QR
+// code lets authenticator apps enroll the account in a single scan.
+func ProvisioningURI(issuer, account string, secret []byte) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+	}
+
+	q := url.Values{}
+	q.Set("secret", EncodeSecret(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period.Seconds())))
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// Step returns the time step that t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the time step that t falls in.
+func Code(secret []byte, t time.Time) string {
+	return codeForStep(secret, Step(t))
+}
+
+// Validate checks a code against the time step that t falls in, and the Skew steps
+// either side of it. If the code is valid it also returns the step that it matched, so
+// that callers can reject a code which has already been used.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected := codeForStep(secret, step)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// Verify is like Validate, but also rejects a code from lastUsedStep or earlier, where
+// lastUsedStep is the step of the last code accepted for the account. This stops an
+// intercepted code from being replayed while it is still valid.
+func Verify(secret []byte, code string, t time.Time, lastUsedStep int64) (int64, bool) {
+	step, ok := Validate(secret, code, t)
+	if !ok || step <= lastUsedStep {
+		return 0, false
+	}
+
+	return step, true
+}
+
+// codeForStep implements the HOTP algorithm from RFC 4226, with the counter set to the
+// time step.
+func codeForStep(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick an offset into the
+	// digest, and the 31 bits starting at that offset become the code.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%modulus)
+}
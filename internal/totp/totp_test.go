@@ -0,0 +1,146 @@
+package totp
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret used by the test vectors in RFC 6238 Appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes. A 6-digit code is the same value modulo 10^6, so it
+	// is the last six digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-Digits:]
+
+		got := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if got != want {
+			t.Errorf("Code at %d = %q; want %q", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	// A fake clock in the middle of a time step.
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		valid  bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -Period, true},
+		{"next step", Period, true},
+		{"two steps behind", -2 * Period, false},
+		{"two steps ahead", 2 * Period, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := Code(rfcSecret, now.Add(tt.offset))
+
+			matched, ok := Validate(rfcSecret, code, now)
+			if ok != tt.valid {
+				t.Fatalf("Validate ok = %t; want %t", ok, tt.valid)
+			}
+			if ok && matched != step+int64(tt.offset/Period) {
+				t.Errorf("Validate step = %d; want %d", matched, step+int64(tt.offset/Period))
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870822", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) ok = true; want false", code)
+		}
+	}
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code := Code(rfcSecret, now)
+
+	step, ok := Verify(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("first use of the code was rejected")
+	}
+
+	// The same code, later in the same time step, is still valid but has been used.
+	if _, ok := Verify(rfcSecret, code, now.Add(time.Second), step); ok {
+		t.Error("code was accepted twice in the same step")
+	}
+
+	// So is a code from the previous step, which is still within the skew window.
+	previous := Code(rfcSecret, now.Add(-Period))
+	if _, ok := Verify(rfcSecret, previous, now, step); ok {
+		t.Error("code from an earlier step was accepted after a later one was used")
+	}
+
+	// The next step's code is fine.
+	next := Code(rfcSecret, now.Add(Period))
+	if _, ok := Verify(rfcSecret, next, now.Add(Period), step); !ok {
+		t.Error("code from the next step was rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("got %d codes and %d hashes; want 10 of each", len(codes), len(hashes))
+	}
+
+	format := regexp.MustCompile(`^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`)
+	seen := make(map[string]bool)
+
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q isn't in the form XXXX-XXXX-XXXX-XXXX", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was generated twice", code)
+		}
+		seen[code] = true
+
+		if !bytes.Equal(HashRecoveryCode(code), hashes[i]) {
+			t.Errorf("hash of %q doesn't match the generated hash", code)
+		}
+	}
+}
+
+func TestHashRecoveryCodeNormalizes(t *testing.T) {
+	want := HashRecoveryCode("ABCD-EFGH-IJKL-MNOP")
+
+	for _, code := range []string{"abcd-efgh-ijkl-mnop", "ABCDEFGHIJKLMNOP", "abcd efgh ijkl mnop"} {
+		if !bytes.Equal(HashRecoveryCode(code), want) {
+			t.Errorf("HashRecoveryCode(%q) differs from the displayed form", code)
+		}
+	}
+
+	if bytes.Equal(HashRecoveryCode("ABCD-EFGH-IJKL-MNOQ"), want) {
+		t.Error("different codes have the same hash")
+	}
+}
@@ -0,0 +1,28 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// WriterExporter writes each span to an io.Writer, such as os.Stdout or a file, as a
+// line of JSON.
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter returns a WriterExporter which writes to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// ExportSpan writes the span. Errors are ignored, as there is nowhere better to report
+// them, and losing a span mustn't affect the operation that it describes.
+func (e *WriterExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.enc.Encode(span)
+}
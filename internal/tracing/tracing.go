@@ -0,0 +1,285 @@
+// Package tracing records spans: named, timed operations such as serving a request,
+// running a query or sending an email. Spans started from a context which carries a
+// span become its children, so that everything done for one request shares the same
+// trace ID and can be pieced back together.
+//
+// Trace context is carried in the W3C traceparent header
+// (https://www.w3.org/TR/trace-context/): ParseTraceparent reads an incoming header so
+// that a request continues its caller's trace, and SpanContext.Traceparent formats one
+// for a response or an outgoing request. Finished spans are handed to an Exporter.
+// The only exporter writes them as JSON lines, so tracing works without a collector.
+//
+// Until SetDefault is called, Start returns a nil *Span. Every method of Span accepts a
+// nil receiver, so code can be instrumented unconditionally.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace, that is every span recorded for one request.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span which is propagated to its children, including
+// those in other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are both set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats sc as the value of a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses the value of a traceparent header. It reports false if the
+// value is malformed, in which case the header should be ignored and a new trace
+// started.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+
+	// version "-" trace-id "-" parent-id "-" trace-flags, all lowercase hex. Later
+	// versions may append more fields, which we don't understand but can skip.
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, false
+	}
+	version := s[0:2]
+	if version == "ff" || !isLowerHex(version) || (version == "00" && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], s[3:35]) || !decodeHex(sc.SpanID[:], s[36:52]) || !decodeHex(flags[:], s[53:55]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if !isLowerHex(s) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Span is a timed operation. Create one with Start and finish it with End.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	name  string
+	attrs map[string]any
+	err   string
+	ended bool
+}
+
+// SpanContext returns the span's trace and span IDs. It returns the zero SpanContext
+// for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName changes the span's name, for when a better one is only known later on.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttr records a key and value which describe the operation.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// RecordError marks the span as failed, if err isn't nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and, if it is sampled, exports it. Only the first call has any
+// effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+
+	data := SpanData{
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Name:       s.name,
+		Start:      s.start,
+		End:        end,
+		DurationMS: float64(end.Sub(s.start)) / float64(time.Millisecond),
+		Attributes: s.attrs,
+		Error:      s.err,
+	}
+	if s.parent != (SpanID{}) {
+		data.ParentID = s.parent.String()
+	}
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// SpanData is a finished span, as it is exported.
+type SpanData struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Name       string         `json:"name"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	DurationMS float64        `json:"duration_ms"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Exporter receives spans as they finish. ExportSpan may be called concurrently.
+type Exporter interface {
+	ExportSpan(SpanData)
+}
+
+// Tracer starts spans and sends them to an Exporter.
+type Tracer struct {
+	exporter Exporter
+	sample   float64
+}
+
+// New returns a Tracer which exports spans to exporter. A new trace is recorded with
+// probability sample, between 0 and 1; traces continued from a parent follow the
+// parent's sampling decision instead.
+func New(exporter Exporter, sample float64) *Tracer {
+	return &Tracer{exporter: exporter, sample: sample}
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault makes t the Tracer used by Start.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start starts a span with the default Tracer. It returns ctx unchanged and a nil span
+// if SetDefault hasn't been called.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name)
+}
+
+type spanContextKey struct{}
+
+type remoteContextKey struct{}
+
+// Start starts a span and returns a copy of ctx which carries it. The span is a child of
+// the span in ctx, if there is one, or else of the remote parent added by
+// ContextWithRemoteParent.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{tracer: t, name: name, start: time.Now()}
+
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample >= 1 || mathrand.Float64() < t.sample
+	}
+	rand.Read(s.sc.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the SpanContext of the span carried by ctx, or of the
+// remote parent if there is no local span. It returns the zero SpanContext if there is
+// neither.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc
+	}
+	sc, _ := ctx.Value(remoteContextKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteParent returns a copy of ctx in which spans are started as children
+// of sc, a span in another service, usually parsed from a traceparent header.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+// The example header from the W3C Trace Context specification.
+const (
+	exampleTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	exampleSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-" + exampleTraceID + "-" + exampleSpanID + "-01", true, true},
+		{"not sampled", "00-" + exampleTraceID + "-" + exampleSpanID + "-00", true, false},
+		{"unknown flags are ignored", "00-" + exampleTraceID + "-" + exampleSpanID + "-03", true, true},
+
+		// A later version may add fields after the flags, which are skipped, but
+		// the fields we know about must still be well formed.
+		{"future version", "01-" + exampleTraceID + "-" + exampleSpanID + "-01", true, true},
+		{"future version with more fields", "cc-" + exampleTraceID + "-" + exampleSpanID + "-01-what-the-future-holds", true, true},
+		{"future version without a separator", "cc-" + exampleTraceID + "-" + exampleSpanID + "-01what", false, false},
+		{"future version with a bad trace ID", "cc-" + exampleTraceID[:31] + "x-" + exampleSpanID + "-01-more", false, false},
+
+		// Version ff is forbidden.
+		{"version ff", "ff-" + exampleTraceID + "-" + exampleSpanID + "-01", false, false},
+
+		{"empty", "", false, false},
+		{"version 00 with more fields", "00-" + exampleTraceID + "-" + exampleSpanID + "-01-more", false, false},
+		{"too short", "00-" + exampleTraceID + "-" + exampleSpanID[:15] + "-01", false, false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + exampleSpanID + "-01", false, false},
+		{"uppercase version", "0A-" + exampleTraceID + "-" + exampleSpanID + "-01", false, false},
+		{"non-hex flags", "00-" + exampleTraceID + "-" + exampleSpanID + "-0g", false, false},
+		{"wrong separator", "00_" + exampleTraceID + "-" + exampleSpanID + "-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + exampleSpanID + "-01", false, false},
+		{"zero span ID", "00-" + exampleTraceID + "-0000000000000000-01", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.valid {
+				t.Fatalf("ParseTraceparent(%q) ok = %t; want %t", tt.header, ok, tt.valid)
+			}
+			if !ok {
+				return
+			}
+
+			if sc.TraceID.String() != exampleTraceID || sc.SpanID.String() != exampleSpanID {
+				t.Errorf("got trace %s, span %s; want %s, %s", sc.TraceID, sc.SpanID, exampleTraceID, exampleSpanID)
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("sampled = %t; want %t", sc.Sampled, tt.sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, header := range []string{
+		"00-" + exampleTraceID + "-" + exampleSpanID + "-01",
+		"00-" + exampleTraceID + "-" + exampleSpanID + "-00",
+	} {
+		sc, ok := ParseTraceparent(header)
+		if !ok {
+			t.Fatalf("ParseTraceparent(%q) failed", header)
+		}
+		if got := sc.Traceparent(); got != header {
+			t.Errorf("Traceparent() = %q; want %q", got, header)
+		}
+	}
+}
+
+func TestStartContinuesRemoteParent(t *testing.T) {
+	parent, _ := ParseTraceparent("00-" + exampleTraceID + "-" + exampleSpanID + "-01")
+
+	tracer := New(nil, 0)
+	_, span := tracer.Start(ContextWithRemoteParent(context.Background(), parent), "request")
+
+	sc := span.SpanContext()
+	if sc.TraceID != parent.TraceID {
+		t.Errorf("trace ID = %s; want the parent's %s", sc.TraceID, parent.TraceID)
+	}
+	if sc.SpanID == parent.SpanID || !sc.IsValid() {
+		t.Errorf("span ID = %s; want a new one", sc.SpanID)
+	}
+	// The parent's sampling decision is kept, even though this tracer samples nothing.
+	if !sc.Sampled {
+		t.Error("span isn't sampled although its parent is")
+	}
+	if span.parent != parent.SpanID {
+		t.Errorf("parent = %s; want %s", span.parent, parent.SpanID)
+	}
+}
@@ -0,0 +1,84 @@
+// Package vcs describes the build of the running binary: its version, the git commit it
+// was built from, when it was built and with which Go version.
+//
+// The values are set at build time with linker flags, for example
+//
+//	go build -ldflags="-X github.com/ridwanulhoquejr/lets-go-further/internal/vcs.commit=$(git rev-parse HEAD)" ./cmd/api
+//
+// which is what `make build/api` does. Anything that isn't set that way is read from the
+// build information that the Go toolchain embeds, which includes the commit when the
+// binary was built inside a git checkout.
+package vcs
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// These are set with -ldflags="-X ...". They are strings because -X can only set
+// strings; modified is "true" or "false".
+var (
+	version    string
+	commit     string
+	commitTime string
+	buildTime  string
+	modified   string
+)
+
+// Info describes a build.
+type Info struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	CommitTime string `json:"commit_time"`
+	BuildTime  string `json:"build_time"`
+	GoVersion  string `json:"go_version"`
+	// Modified is true if the build included changes which hadn't been committed.
+	Modified bool `json:"modified"`
+}
+
+// Get returns the build information of the running binary. Settings which aren't known
+// are empty, apart from the version which is "dev".
+func Get() Info {
+	return get()
+}
+
+var get = sync.OnceValue(func() Info {
+	info := Info{
+		Version:    version,
+		Commit:     commit,
+		CommitTime: commitTime,
+		BuildTime:  buildTime,
+		GoVersion:  runtime.Version(),
+	}
+	info.Modified, _ = strconv.ParseBool(modified)
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		// go run and go build without a version tag both report "(devel)".
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+
+		// Only fall back to the embedded VCS settings if the commit wasn't set at build
+		// time, so that the fields always describe the same commit.
+		if info.Commit == "" {
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Commit = s.Value
+				case "vcs.time":
+					info.CommitTime = s.Value
+				case "vcs.modified":
+					info.Modified = s.Value == "true"
+				}
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+
+	return info
+})
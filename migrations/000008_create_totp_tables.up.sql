@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS users_totp (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    secret bytea NOT NULL,
+    enabled boolean NOT NULL DEFAULT FALSE,
+    last_used_step bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+-- A role inherits every permission granted to its parent roles.
+CREATE TABLE IF NOT EXISTS roles_parents (
+    role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    parent_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, parent_id),
+    CONSTRAINT roles_parents_self_check CHECK (role_id <> parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+
+-- seed the default roles: an editor can do everything a viewer can, and an admin
+-- can do everything an editor can.
+INSERT INTO
+        roles (name)
+    VALUES
+        ('viewer'),
+        ('editor'),
+        ('admin');
+
+INSERT INTO roles_parents (role_id, parent_id)
+    SELECT child.id, parent.id
+    FROM roles child, roles parent
+    WHERE (child.name, parent.name) IN (('editor', 'viewer'), ('admin', 'editor'));
+
+INSERT INTO roles_permissions (role_id, permission_id)
+    SELECT roles.id, permissions.id
+    FROM roles, permissions
+    WHERE (roles.name, permissions.code) IN (
+        ('viewer', 'movie:read'),
+        ('editor', 'movie:write'),
+        ('admin', 'users:admin')
+    );
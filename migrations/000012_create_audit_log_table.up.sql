@@ -0,0 +1,12 @@
+-- target_user_id deliberately has no foreign key, so that the record of what happened
+-- to a user outlives the user.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    target_user_id bigint,
+    action text NOT NULL,
+    details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_target_user_id_idx ON audit_log (target_user_id);
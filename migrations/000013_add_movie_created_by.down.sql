@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'movie:write:own';
+
+ALTER TABLE movie DROP COLUMN IF EXISTS created_by;
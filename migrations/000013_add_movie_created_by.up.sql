@@ -0,0 +1,5 @@
+-- Existing movies have no known creator, so created_by stays NULL for them and only
+-- users with the global movie:write permission can change them.
+ALTER TABLE movie ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users(id) ON DELETE SET NULL;
+
+INSERT INTO permissions (code) VALUES ('movie:write:own');
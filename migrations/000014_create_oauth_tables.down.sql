@@ -0,0 +1,7 @@
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS permissions,
+    DROP COLUMN IF EXISTS client_id;
+
+DROP TABLE IF EXISTS oauth_consents;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,41 @@
+-- Clients registered by third-party developers. Public clients, such as single page
+-- and mobile apps, can't keep a secret and have a NULL secret_hash. permissions holds
+-- the permission codes that the client may ever ask for.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    secret_hash bytea,
+    redirect_uris text[] NOT NULL,
+    permissions text[] NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS oauth_clients_user_id_idx ON oauth_clients (user_id);
+
+-- Authorization codes are single use and short lived. code_challenge is the PKCE S256
+-- challenge that the code verifier has to match.
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    hash bytea PRIMARY KEY,
+    client_id text NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    permissions text[] NOT NULL,
+    code_challenge text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- The permission codes that a user has agreed to let a client use on their behalf.
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id text NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    permissions text[] NOT NULL,
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- OAuth access tokens live in the tokens table alongside the other tokens, with the
+-- client that they were issued to and the permission codes that they are limited to.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS client_id text REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS permissions text[];
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'admin:impersonate';
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,6 @@
+-- Impersonation tokens authenticate as their user_id, but record the administrator who
+-- minted them in impersonator_id. The permission isn't part of any role, so it has to
+-- be granted to each support engineer individually.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users(id) ON DELETE CASCADE;
+
+INSERT INTO permissions (code) VALUES ('admin:impersonate');
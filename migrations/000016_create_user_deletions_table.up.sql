@@ -0,0 +1,10 @@
+-- A row here means that the user has asked for their account to be deleted. The
+-- deletion job removes the account once scheduled_for has passed, unless the user
+-- cancels first.
+CREATE TABLE IF NOT EXISTS user_deletions (
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    scheduled_for timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_deletions_scheduled_for_idx ON user_deletions (scheduled_for);
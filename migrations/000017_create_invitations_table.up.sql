@@ -0,0 +1,12 @@
+-- An invitation lets someone sign up while public signup is disabled. Only the SHA-256
+-- hash of the invitation token is stored, like the tokens table. Each email address
+-- has at most one outstanding invitation.
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    email citext NOT NULL UNIQUE,
+    invited_by bigint REFERENCES users(id) ON DELETE SET NULL,
+    permissions text[] NOT NULL DEFAULT '{}',
+    hash bytea NOT NULL UNIQUE,
+    expiry timestamp(0) with time zone NOT NULL
+);
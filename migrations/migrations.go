@@ -0,0 +1,41 @@
+// Package migrations holds the SQL migrations which are applied with the migrate tool,
+// so that the application can tell which schema version it was built for.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Latest returns the version of the newest migration, which is the schema version that
+// the application expects the database to be at. Migration files are named like
+// 000017_create_invitations_table.up.sql, starting with their version.
+func Latest() (int64, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			return 0, fmt.Errorf("migration %s: name must start with a version", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: name must start with a version", entry.Name())
+		}
+
+		latest = max(latest, version)
+	}
+
+	return latest, nil
+}